@@ -0,0 +1,255 @@
+package cidaasutils
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// InvalidAppError is returned if an app without name is created.
+var InvalidAppError = errors.New("app is invalid")
+
+// Grant types which can be allowed for an app.
+const (
+	GrantTypeAuthorizationCode = "authorization_code"
+	GrantTypeRefreshToken      = "refresh_token"
+	GrantTypeClientCredentials = "client_credentials"
+	GrantTypePassword          = "password"
+	GrantTypeDeviceCode        = "urn:ietf:params:oauth:grant-type:device_code"
+	GrantTypeTokenExchange     = "urn:ietf:params:oauth:grant-type:token-exchange"
+)
+
+// ClientApp is an OAuth client application of the tenant.
+//
+// It is meant to be used declaratively: DiffApp and ApplyApp only manage the fields which are set
+// in the desired app. A nil slice leaves the field as it is, an empty slice removes all entries.
+type ClientApp struct {
+	ClientID          string   `json:"client_id,omitempty"`
+	ClientName        string   `json:"client_name,omitempty"`
+	ClientType        string   `json:"client_type,omitempty"`
+	RedirectURIs      []string `json:"redirect_uris"`
+	AllowedLogoutURLs []string `json:"allowed_logout_urls"`
+	AllowedScopes     []string `json:"allowed_scopes"`
+	GrantTypes        []string `json:"grant_types"`
+	// ClientSecret is only returned when the app is created or the secret is rotated.
+	ClientSecret string `json:"client_secret,omitempty"`
+	// Extra contains all fields Cidaas sent which are not known to this struct.
+	// They are sent back unchanged on updates.
+	Extra map[string]json.RawMessage `json:"-"`
+}
+
+func (a *ClientApp) UnmarshalJSON(data []byte) error {
+	type plain ClientApp
+	if err := json.Unmarshal(data, (*plain)(a)); err != nil {
+		return err
+	}
+	extra, err := extraFields(data, a)
+	a.Extra = extra
+	return err
+}
+
+func (a ClientApp) MarshalJSON() ([]byte, error) {
+	type plain ClientApp
+	return marshalWithExtra(plain(a), a.Extra)
+}
+
+// AppDrift is a field of an app which differs from the desired state.
+type AppDrift struct {
+	Field   string
+	Current interface{}
+	Desired interface{}
+}
+
+func (d AppDrift) String() string {
+	return fmt.Sprintf("%s: %v -> %v", d.Field, d.Current, d.Desired)
+}
+
+type ClientAppResponse struct {
+	Success bool      `json:"success"`
+	Status  int       `json:"status"`
+	Data    ClientApp `json:"data"`
+}
+
+type ClientAppsResponse struct {
+	Success bool        `json:"success"`
+	Status  int         `json:"status"`
+	Data    []ClientApp `json:"data"`
+}
+
+// ListApps returns all apps of the tenant.
+func (u *CidaasUtils) ListApps(ctx context.Context) ([]ClientApp, error) {
+	token, err := u.GetMyAccessToken()
+	if err != nil {
+		return nil, err
+	}
+
+	var result ClientAppsResponse
+	err = u.doRequest(&RequestInit{Operation: "ListApps", Path: appsEndpoint, Token: token.Raw, Context: ctx}, &result)
+	if errors.Is(err, NoResultError) {
+		return []ClientApp{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return result.Data, nil
+}
+
+// GetApp returns the app with the given client id.
+func (u *CidaasUtils) GetApp(ctx context.Context, clientID string) (*ClientApp, error) {
+	token, err := u.GetMyAccessToken()
+	if err != nil {
+		return nil, err
+	}
+
+	path := strings.Replace(appEndpoint, "{client}", url.PathEscape(clientID), 1)
+
+	var result ClientAppResponse
+	err = u.doRequest(&RequestInit{Operation: "GetApp", Path: path, Token: token.Raw, Context: ctx}, &result)
+	if err != nil {
+		return nil, err
+	}
+
+	return &result.Data, nil
+}
+
+// CreateApp creates a new app and returns it including the generated client id and secret.
+func (u *CidaasUtils) CreateApp(ctx context.Context, app *ClientApp) (*ClientApp, error) {
+	if app.ClientName == "" {
+		return nil, fmt.Errorf("%w: client_name is required", InvalidAppError)
+	}
+
+	token, err := u.GetMyAccessToken()
+	if err != nil {
+		return nil, err
+	}
+
+	var result ClientAppResponse
+	err = u.doRequest(&RequestInit{Operation: "CreateApp", Path: appsEndpoint, Token: token.Raw, Method: "POST", BodyJSON: app, Context: ctx}, &result)
+	if err != nil {
+		return nil, err
+	}
+
+	return &result.Data, nil
+}
+
+// UpdateApp replaces the app with the id app.ClientID.
+func (u *CidaasUtils) UpdateApp(ctx context.Context, app *ClientApp) error {
+	body := *app
+	body.ClientSecret = ""
+	path := strings.Replace(appEndpoint, "{client}", url.PathEscape(app.ClientID), 1)
+	return u.doAdminRequest(&RequestInit{Operation: "UpdateApp", Path: path, Method: "PUT", BodyJSON: body, Context: ctx})
+}
+
+// DeleteApp deletes the app. Deleting an app which does not exist is not an error.
+func (u *CidaasUtils) DeleteApp(ctx context.Context, clientID string) error {
+	path := strings.Replace(appEndpoint, "{client}", url.PathEscape(clientID), 1)
+	err := u.doAdminRequest(&RequestInit{Operation: "DeleteApp", Path: path, Method: "DELETE", Context: ctx})
+	if IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// RotateAppSecret generates a new client secret and returns it. The old secret stops working immediately.
+func (u *CidaasUtils) RotateAppSecret(ctx context.Context, clientID string) (string, error) {
+	token, err := u.GetMyAccessToken()
+	if err != nil {
+		return "", err
+	}
+
+	path := strings.Replace(appSecretEndpoint, "{client}", url.PathEscape(clientID), 1)
+
+	var result ClientAppResponse
+	err = u.doRequest(&RequestInit{Operation: "RotateAppSecret", Path: path, Token: token.Raw, Method: "POST", Context: ctx}, &result)
+	if err != nil {
+		return "", err
+	}
+	if result.Data.ClientSecret == "" {
+		return "", errors.New("cidaas did not return a client secret")
+	}
+
+	return result.Data.ClientSecret, nil
+}
+
+// DiffApp returns all managed fields in which current differs from desired.
+// The order of the entries of a list does not matter.
+func DiffApp(current, desired *ClientApp) []AppDrift {
+	var result []AppDrift
+
+	diffString := func(field string, current string, desired string) {
+		if desired != "" && current != desired {
+			result = append(result, AppDrift{Field: field, Current: current, Desired: desired})
+		}
+	}
+	diffList := func(field string, current []string, desired []string) {
+		if desired != nil && !equalStringSets(current, desired) {
+			result = append(result, AppDrift{Field: field, Current: current, Desired: desired})
+		}
+	}
+
+	diffString("client_name", current.ClientName, desired.ClientName)
+	diffString("client_type", current.ClientType, desired.ClientType)
+	diffList("redirect_uris", current.RedirectURIs, desired.RedirectURIs)
+	diffList("allowed_logout_urls", current.AllowedLogoutURLs, desired.AllowedLogoutURLs)
+	diffList("allowed_scopes", current.AllowedScopes, desired.AllowedScopes)
+	diffList("grant_types", current.GrantTypes, desired.GrantTypes)
+	return result
+}
+
+// ApplyApp brings the app into the desired state.
+// An app without ClientID is created, otherwise the app is updated if DiffApp reports a drift.
+// It returns the app after the change and the drift which was corrected.
+func (u *CidaasUtils) ApplyApp(ctx context.Context, desired *ClientApp) (*ClientApp, []AppDrift, error) {
+	if desired.ClientID == "" {
+		app, err := u.CreateApp(ctx, desired)
+		return app, nil, err
+	}
+
+	current, err := u.GetApp(ctx, desired.ClientID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	drift := DiffApp(current, desired)
+	if len(drift) == 0 {
+		return current, nil, nil
+	}
+
+	updated := mergeApp(current, desired)
+	if err := u.UpdateApp(ctx, updated); err != nil {
+		return nil, nil, err
+	}
+	return updated, drift, nil
+}
+
+// mergeApp returns current with all managed fields of desired.
+func mergeApp(current, desired *ClientApp) *ClientApp {
+	result := *current
+	if desired.ClientName != "" {
+		result.ClientName = desired.ClientName
+	}
+	if desired.ClientType != "" {
+		result.ClientType = desired.ClientType
+	}
+	if desired.RedirectURIs != nil {
+		result.RedirectURIs = desired.RedirectURIs
+	}
+	if desired.AllowedLogoutURLs != nil {
+		result.AllowedLogoutURLs = desired.AllowedLogoutURLs
+	}
+	if desired.AllowedScopes != nil {
+		result.AllowedScopes = desired.AllowedScopes
+	}
+	if desired.GrantTypes != nil {
+		result.GrantTypes = desired.GrantTypes
+	}
+	return &result
+}
+
+// equalStringSets reports whether both slices contain the same strings, ignoring order and duplicates.
+func equalStringSets(a []string, b []string) bool {
+	return includesStrings(a, b) && includesStrings(b, a)
+}
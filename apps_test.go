@@ -0,0 +1,113 @@
+package cidaasutils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCidaasUtils_Apps(t *testing.T) {
+	var requests []string
+	utils, server := mockAdminUtils(func(writer http.ResponseWriter, request *http.Request) {
+		body, _ := ioutil.ReadAll(request.Body)
+		requests = append(requests, fmt.Sprintf("%s %s %s", request.Method, request.URL.Path, body))
+
+		switch {
+		case request.Method == "GET" && request.URL.Path == "/apps-srv/clients":
+			writer.Write([]byte(`{"success":true,"data":[{"client_id":"c1","client_name":"App"}]}`))
+		case request.Method == "POST" && request.URL.Path == "/apps-srv/clients":
+			writer.Write([]byte(`{"success":true,"data":{"client_id":"c2","client_name":"New","client_secret":"s1"}}`))
+		case request.Method == "POST" && request.URL.Path == "/apps-srv/clients/c1/secret":
+			writer.Write([]byte(`{"success":true,"data":{"client_id":"c1","client_secret":"s2"}}`))
+		case request.Method == "DELETE":
+			writer.WriteHeader(404)
+		default:
+			writer.WriteHeader(204)
+		}
+	})
+	defer server.Close()
+	ctx := context.Background()
+
+	apps, err := utils.ListApps(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, "c1", apps[0].ClientID)
+
+	_, err = utils.CreateApp(ctx, &ClientApp{})
+	assert.True(t, errors.Is(err, InvalidAppError))
+	app, err := utils.CreateApp(ctx, &ClientApp{ClientName: "New", GrantTypes: []string{GrantTypeClientCredentials}})
+	assert.Nil(t, err)
+	assert.Equal(t, "c2", app.ClientID)
+	assert.Equal(t, "s1", app.ClientSecret)
+
+	secret, err := utils.RotateAppSecret(ctx, "c1")
+	assert.Nil(t, err)
+	assert.Equal(t, "s2", secret)
+
+	assert.Nil(t, utils.DeleteApp(ctx, "c3"))
+
+	assert.Equal(t, []string{
+		"GET /apps-srv/clients ",
+		`POST /apps-srv/clients {"client_name":"New","redirect_uris":null,"allowed_logout_urls":null,"allowed_scopes":null,"grant_types":["client_credentials"]}`,
+		"POST /apps-srv/clients/c1/secret ",
+		"DELETE /apps-srv/clients/c3 ",
+	}, requests)
+}
+
+func TestDiffApp(t *testing.T) {
+	current := &ClientApp{
+		ClientID:      "c1",
+		ClientName:    "App",
+		RedirectURIs:  []string{"https://a.example.com", "https://b.example.com"},
+		AllowedScopes: []string{"openid", "profile"},
+		GrantTypes:    []string{GrantTypeAuthorizationCode},
+	}
+
+	assert.Nil(t, DiffApp(current, &ClientApp{ClientID: "c1"}))
+	assert.Nil(t, DiffApp(current, &ClientApp{RedirectURIs: []string{"https://b.example.com", "https://a.example.com"}}))
+
+	drift := DiffApp(current, &ClientApp{
+		ClientName:    "App",
+		AllowedScopes: []string{"openid"},
+		GrantTypes:    []string{},
+	})
+	assert.Equal(t, []AppDrift{
+		{Field: "allowed_scopes", Current: []string{"openid", "profile"}, Desired: []string{"openid"}},
+		{Field: "grant_types", Current: []string{GrantTypeAuthorizationCode}, Desired: []string{}},
+	}, drift)
+	assert.Equal(t, "allowed_scopes: [openid profile] -> [openid]", drift[0].String())
+}
+
+func TestCidaasUtils_ApplyApp(t *testing.T) {
+	var updates []string
+	utils, server := mockAdminUtils(func(writer http.ResponseWriter, request *http.Request) {
+		switch request.Method {
+		case "GET":
+			writer.Write([]byte(`{"success":true,"data":{"client_id":"c1","client_name":"App","allowed_scopes":["openid"],"grant_types":["authorization_code"],"redirect_uris":["https://example.com"],"allowed_logout_urls":[],"company_website":"https://example.com"}}`))
+		case "PUT":
+			body, _ := ioutil.ReadAll(request.Body)
+			updates = append(updates, string(body))
+			writer.Write([]byte(`{"success":true}`))
+		}
+	})
+	defer server.Close()
+	ctx := context.Background()
+
+	app, drift, err := utils.ApplyApp(ctx, &ClientApp{ClientID: "c1", AllowedScopes: []string{"openid"}})
+	assert.Nil(t, err)
+	assert.Nil(t, drift)
+	assert.Equal(t, "App", app.ClientName)
+	assert.Empty(t, updates)
+
+	app, drift, err = utils.ApplyApp(ctx, &ClientApp{ClientID: "c1", AllowedScopes: []string{"openid", "email"}})
+	assert.Nil(t, err)
+	assert.Len(t, drift, 1)
+	assert.Equal(t, []string{"openid", "email"}, app.AllowedScopes)
+	assert.Equal(t, []string{
+		`{"allowed_logout_urls":[],"allowed_scopes":["openid","email"],"client_id":"c1","client_name":"App","company_website":"https://example.com","grant_types":["authorization_code"],"redirect_uris":["https://example.com"]}`,
+	}, updates)
+}
@@ -1,7 +1,11 @@
 package cidaasutils
 
 import (
+	"context"
+	"encoding/json"
 	"net/url"
+	"strings"
+	"time"
 
 	"github.com/dgrijalva/jwt-go"
 )
@@ -11,11 +15,16 @@ type AccessTokenResult struct {
 	AccessToken  string `json:"access_token"`
 	RefreshToken string `json:"refresh_token"`
 	ExpiresIn    int    `json:"expires_in"`
+	// IssuedTokenType is only set by ExchangeToken.
+	IssuedTokenType string `json:"issued_token_type,omitempty"`
 }
 
 // GetMyAccessToken returns the access token for the configured user.
 // It will use the Admin credentials.
 func (u *CidaasUtils) GetMyAccessToken() (*jwt.Token, error) {
+	u.tokenMu.Lock()
+	defer u.tokenMu.Unlock()
+
 	accessToken := u.myAccessToken
 	if accessToken != nil && !IsTokenExpired(accessToken) {
 		return accessToken, nil
@@ -29,7 +38,7 @@ func (u *CidaasUtils) GetMyAccessToken() (*jwt.Token, error) {
 	data.Add("password", u.options.AdminPassword)
 
 	var result AccessTokenResult
-	err := u.doRequest(&RequestInit{Path: tokenEndpoint, BodyForm: &data, Method: "POST"}, &result)
+	err := u.doRequest(&RequestInit{Operation: "GetMyAccessToken", Path: tokenEndpoint, BodyForm: &data, Method: "POST"}, &result)
 	if err != nil {
 		return nil, err
 	}
@@ -42,8 +51,78 @@ func (u *CidaasUtils) GetMyAccessToken() (*jwt.Token, error) {
 	return token, err
 }
 
+// resetMyAccessToken forgets the admin token, e.g. because it was rejected.
+// A token which was already replaced by another caller is kept.
+func (u *CidaasUtils) resetMyAccessToken(token *jwt.Token) {
+	u.tokenMu.Lock()
+	defer u.tokenMu.Unlock()
+	if u.myAccessToken == token {
+		u.myAccessToken = nil
+	}
+}
+
+// tokenExpiryLeeway is the time before its expiry from which a token is treated as expired,
+// so that it does not expire while a request is on its way.
+var tokenExpiryLeeway = 30 * time.Second
+
+// IsTokenExpired reports whether the token expires within the next 30 seconds.
+// Tokens without exp claim never expire.
 func IsTokenExpired(token *jwt.Token) bool {
-	return false
+	exp, ok := tokenExpiry(token.Claims)
+	if !ok {
+		return false
+	}
+	return jwt.TimeFunc().Add(tokenExpiryLeeway).Unix() >= exp.Unix()
+}
+
+// tokenExpiry returns the time of the exp claim and false if there is none.
+func tokenExpiry(tokenClaims jwt.Claims) (time.Time, bool) {
+	var claims jwt.MapClaims
+	switch c := tokenClaims.(type) {
+	case *jwt.MapClaims:
+		claims = *c
+	case jwt.MapClaims:
+		claims = c
+	default:
+		return time.Time{}, false
+	}
+
+	var exp float64
+	switch value := claims["exp"].(type) {
+	case float64:
+		exp = value
+	case int64:
+		exp = float64(value)
+	case json.Number:
+		exp, _ = value.Float64()
+	default:
+		return time.Time{}, false
+	}
+	return time.Unix(int64(exp), 0), true
+}
+
+// ClientCredentialsFlow retrieves an access token for the app itself, e.g. to call other services.
+func (u *CidaasUtils) ClientCredentialsFlow(ctx context.Context, scopes []string) (*AccessTokenResult, error) {
+	data := url.Values{}
+	data.Add("grant_type", GrantTypeClientCredentials)
+	data.Add("client_id", u.options.ClientID)
+	data.Add("client_secret", u.options.ClientSecret)
+	if len(scopes) > 0 {
+		data.Add("scope", strings.Join(scopes, " "))
+	}
+
+	var result AccessTokenResult
+	err := u.doRequest(&RequestInit{Operation: "ClientCredentialsFlow", Path: tokenEndpoint, BodyForm: &data, Method: "POST", Context: ctx}, &result)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = u.ValidateJWT(result.AccessToken)
+	if err != nil {
+		return nil, err
+	}
+
+	return &result, nil
 }
 
 // AuthorizationCodeFlow completes the authorization flow using a code and a redirect URL.
@@ -57,7 +136,7 @@ func (u *CidaasUtils) AuthorizationCodeFlow(code string, redirectURL string) (*A
 	data.Add("redirect_uri", redirectURL)
 
 	var result AccessTokenResult
-	err := u.doRequest(&RequestInit{Path: tokenEndpoint, BodyForm: &data, Method: "POST"}, &result)
+	err := u.doRequest(&RequestInit{Operation: "AuthorizationCodeFlow", Path: tokenEndpoint, BodyForm: &data, Method: "POST"}, &result)
 	if err != nil {
 		return nil, err
 	}
@@ -79,7 +158,7 @@ func (u *CidaasUtils) RefreshTokenFlow(refreshToken string) (*AccessTokenResult,
 	data.Add("refresh_token", refreshToken)
 
 	var result AccessTokenResult
-	err := u.doRequest(&RequestInit{Path: tokenEndpoint, BodyForm: &data, Method: "POST"}, &result)
+	err := u.doRequest(&RequestInit{Operation: "RefreshTokenFlow", Path: tokenEndpoint, BodyForm: &data, Method: "POST"}, &result)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,303 @@
+package cidaasutils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CircuitOpenError is returned without contacting Cidaas if the circuit breaker
+// of the endpoint group is open. Handlers usually want to map it to 503.
+var CircuitOpenError = errors.New("cidaas circuit breaker is open")
+
+// EndpointGroup groups the Cidaas endpoints which share a circuit breaker and rate limit.
+type EndpointGroup string
+
+const (
+	// EndpointGroupToken contains the token endpoint used by all OAuth flows.
+	EndpointGroupToken EndpointGroup = "token"
+	// EndpointGroupUsers contains the user and admin APIs.
+	EndpointGroupUsers EndpointGroup = "users"
+	// EndpointGroupJWKS contains the JWKs endpoint used to validate tokens.
+	EndpointGroupJWKS EndpointGroup = "jwks"
+)
+
+// endpointGroupFor returns the group of the given request path.
+func endpointGroupFor(path string) EndpointGroup {
+	switch {
+	case strings.Contains(path, jwkEndpoint):
+		return EndpointGroupJWKS
+	case strings.Contains(path, tokenEndpoint):
+		return EndpointGroupToken
+	default:
+		return EndpointGroupUsers
+	}
+}
+
+// CircuitState is the state of a circuit breaker.
+type CircuitState int
+
+const (
+	// CircuitClosed lets all requests pass.
+	CircuitClosed CircuitState = iota
+	// CircuitOpen rejects all requests with CircuitOpenError.
+	CircuitOpen
+	// CircuitHalfOpen lets a limited number of trial requests pass.
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	default:
+		return "half-open"
+	}
+}
+
+// CircuitBreakerOptions configure the circuit breakers, every EndpointGroup gets its own breaker.
+type CircuitBreakerOptions struct {
+	// Number of consecutive failures after which the circuit opens.
+	// Default is 5.
+	FailureThreshold int
+
+	// Time the circuit stays open before trial requests are allowed.
+	// Default is 30 seconds.
+	OpenTimeout time.Duration
+
+	// Number of successful trial requests needed to close the circuit again.
+	// Only this many requests are let through while half-open. Default is 1.
+	SuccessThreshold int
+
+	// OnStateChange is called whenever the circuit of a group changes its state.
+	// The changes of a group are delivered in order, on the goroutine of the request which caused them,
+	// so the callback should return quickly. It is never re-entered: changes caused while it runs,
+	// e.g. by requests to Cidaas from the callback itself, are delivered after it returned.
+	OnStateChange func(group EndpointGroup, from CircuitState, to CircuitState)
+}
+
+// circuitBreaker counts failures for one endpoint group.
+// Network errors and 5xx responses count as failures, all other responses as successes.
+type circuitBreaker struct {
+	group   EndpointGroup
+	options *CircuitBreakerOptions
+	now     func() time.Time
+
+	mu        sync.Mutex
+	state     CircuitState
+	failures  int
+	successes int
+	inFlight  int
+	openedAt  time.Time
+	changes   []stateChange
+	notifying bool
+	// generation is increased on every state change, outcomes of requests
+	// which were allowed in an earlier generation are ignored
+	generation uint64
+}
+
+// stateChange is a transition which has not been passed to OnStateChange yet.
+type stateChange struct {
+	from CircuitState
+	to   CircuitState
+}
+
+func newCircuitBreaker(group EndpointGroup, options *CircuitBreakerOptions) *circuitBreaker {
+	return &circuitBreaker{group: group, options: options, now: time.Now}
+}
+
+func (b *circuitBreaker) failureThreshold() int {
+	if b.options.FailureThreshold > 0 {
+		return b.options.FailureThreshold
+	}
+	return 5
+}
+
+func (b *circuitBreaker) successThreshold() int {
+	if b.options.SuccessThreshold > 0 {
+		return b.options.SuccessThreshold
+	}
+	return 1
+}
+
+func (b *circuitBreaker) openTimeout() time.Duration {
+	if b.options.OpenTimeout > 0 {
+		return b.options.OpenTimeout
+	}
+	return 30 * time.Second
+}
+
+// State returns the current state of the circuit.
+func (b *circuitBreaker) State() CircuitState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == CircuitOpen && b.now().Sub(b.openedAt) >= b.openTimeout() {
+		return CircuitHalfOpen
+	}
+	return b.state
+}
+
+// allow returns CircuitOpenError if no request may be sent at the moment.
+// Every allowed request has to be followed by a call to record or release with the returned generation.
+func (b *circuitBreaker) allow() (uint64, error) {
+	defer b.notify()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == CircuitOpen {
+		if b.now().Sub(b.openedAt) < b.openTimeout() {
+			return 0, fmt.Errorf("%w: %s", CircuitOpenError, b.group)
+		}
+		b.setState(CircuitHalfOpen)
+	}
+
+	if b.state == CircuitHalfOpen {
+		if b.inFlight >= b.successThreshold() {
+			return 0, fmt.Errorf("%w: %s", CircuitOpenError, b.group)
+		}
+		b.inFlight++
+	}
+	return b.generation, nil
+}
+
+// record reports the outcome of a request allowed in the given generation.
+func (b *circuitBreaker) record(generation uint64, success bool) {
+	defer b.notify()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if generation != b.generation {
+		return
+	}
+
+	switch b.state {
+	case CircuitClosed:
+		if success {
+			b.failures = 0
+			return
+		}
+		b.failures++
+		if b.failures >= b.failureThreshold() {
+			b.setState(CircuitOpen)
+		}
+	case CircuitHalfOpen:
+		b.inFlight--
+		if !success {
+			b.setState(CircuitOpen)
+			return
+		}
+		b.successes++
+		if b.successes >= b.successThreshold() {
+			b.setState(CircuitClosed)
+		}
+	}
+}
+
+// release gives back a request allowed in the given generation without recording an outcome.
+func (b *circuitBreaker) release(generation uint64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if generation == b.generation && b.state == CircuitHalfOpen && b.inFlight > 0 {
+		b.inFlight--
+	}
+}
+
+// setState has to be called with the lock held.
+func (b *circuitBreaker) setState(state CircuitState) {
+	from := b.state
+	b.state = state
+	b.generation++
+	b.failures = 0
+	b.successes = 0
+	b.inFlight = 0
+	if state == CircuitOpen {
+		b.openedAt = b.now()
+	}
+
+	if b.options.OnStateChange != nil && from != state {
+		b.changes = append(b.changes, stateChange{from: from, to: state})
+	}
+}
+
+// notify passes all queued changes to OnStateChange. It has to be called without the lock held.
+// Only one goroutine delivers changes at a time, all others return immediately.
+func (b *circuitBreaker) notify() {
+	b.mu.Lock()
+	if b.notifying || len(b.changes) == 0 {
+		b.mu.Unlock()
+		return
+	}
+	b.notifying = true
+
+	for len(b.changes) > 0 {
+		change := b.changes[0]
+		b.changes = b.changes[1:]
+		b.mu.Unlock()
+
+		b.options.OnStateChange(b.group, change.from, change.to)
+
+		b.mu.Lock()
+	}
+	b.notifying = false
+	b.mu.Unlock()
+}
+
+// circuitBreaker returns the circuit breaker for the group or nil if circuit breaking is disabled.
+func (u *CidaasUtils) circuitBreaker(group EndpointGroup) *circuitBreaker {
+	if u.options.CircuitBreaker == nil {
+		return nil
+	}
+
+	u.breakersMu.Lock()
+	defer u.breakersMu.Unlock()
+	if u.breakers == nil {
+		u.breakers = map[EndpointGroup]*circuitBreaker{}
+	}
+	breaker, ok := u.breakers[group]
+	if !ok {
+		breaker = newCircuitBreaker(group, u.options.CircuitBreaker)
+		u.breakers[group] = breaker
+	}
+	return breaker
+}
+
+// CircuitState returns the state of the circuit breaker of the given group.
+// It is always CircuitClosed if no circuit breaker is configured.
+func (u *CidaasUtils) CircuitState(group EndpointGroup) CircuitState {
+	breaker := u.circuitBreaker(group)
+	if breaker == nil {
+		return CircuitClosed
+	}
+	return breaker.State()
+}
+
+// applyCircuitBreaker sends the request through the circuit breaker of its group.
+func (u *CidaasUtils) applyCircuitBreaker(request *http.Request, next http.RoundTripper) (*http.Response, error) {
+	breaker := u.circuitBreaker(endpointGroupFor(request.URL.Path))
+	if breaker == nil {
+		return next.RoundTrip(request)
+	}
+
+	generation, err := breaker.allow()
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := next.RoundTrip(request)
+	switch {
+	case err != nil && errors.Is(request.Context().Err(), context.Canceled):
+		// the caller gave up, this says nothing about the health of Cidaas
+		breaker.release(generation)
+	case err != nil:
+		breaker.record(generation, false)
+	default:
+		breaker.record(generation, resp.StatusCode < 500)
+	}
+	return resp, err
+}
@@ -0,0 +1,187 @@
+package cidaasutils
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCircuitBreaker_OpensAndFailsFast(t *testing.T) {
+	calls := 0
+	utils, server := mockServerUtils(func(writer http.ResponseWriter, request *http.Request) {
+		calls++
+		writer.WriteHeader(http.StatusBadGateway)
+	})
+	defer server.Close()
+
+	changes := make(chan CircuitState, 10)
+	utils.options.CircuitBreaker = &CircuitBreakerOptions{
+		FailureThreshold: 2,
+		OpenTimeout:      time.Hour,
+		OnStateChange: func(group EndpointGroup, from CircuitState, to CircuitState) {
+			assert.Equal(t, EndpointGroupUsers, group)
+			changes <- to
+		},
+	}
+
+	var result SimpleStatusResponse
+	for i := 0; i < 2; i++ {
+		err := utils.doRequest(&RequestInit{Path: "users-srv/user/test", Method: "GET"}, &result)
+		assert.Equal(t, 502, AsCidaasError(err).StatusCode)
+	}
+	assert.Equal(t, CircuitOpen, <-changes)
+	assert.Equal(t, CircuitOpen, utils.CircuitState(EndpointGroupUsers))
+
+	err := utils.doRequest(&RequestInit{Path: "users-srv/user/test", Method: "GET"}, &result)
+	assert.True(t, errors.Is(err, CircuitOpenError))
+	assert.Equal(t, 2, calls)
+
+	// other groups are not affected
+	assert.Equal(t, CircuitClosed, utils.CircuitState(EndpointGroupToken))
+}
+
+func TestCircuitBreaker_HalfOpen(t *testing.T) {
+	now := time.Now()
+	var changes []string
+	breaker := newCircuitBreaker(EndpointGroupToken, &CircuitBreakerOptions{
+		FailureThreshold: 1,
+		OpenTimeout:      time.Minute,
+		SuccessThreshold: 2,
+		OnStateChange: func(group EndpointGroup, from CircuitState, to CircuitState) {
+			changes = append(changes, fmt.Sprintf("%s -> %s", from, to))
+		},
+	})
+	breaker.now = func() time.Time { return now }
+
+	generation, err := breaker.allow()
+	assert.Nil(t, err)
+	breaker.record(generation, false)
+	assert.Equal(t, CircuitOpen, breaker.State())
+	_, err = breaker.allow()
+	assert.NotNil(t, err)
+
+	now = now.Add(time.Minute)
+	assert.Equal(t, CircuitHalfOpen, breaker.State())
+	first, err := breaker.allow()
+	assert.Nil(t, err)
+	second, err := breaker.allow()
+	assert.Nil(t, err)
+	// only as many trial requests as successes are needed
+	_, err = breaker.allow()
+	assert.NotNil(t, err)
+
+	breaker.record(first, true)
+	assert.Equal(t, CircuitHalfOpen, breaker.State())
+	breaker.record(second, true)
+	assert.Equal(t, CircuitClosed, breaker.State())
+
+	// a failed trial request opens the circuit again
+	generation, _ = breaker.allow()
+	breaker.record(generation, false)
+	now = now.Add(time.Minute)
+	generation, err = breaker.allow()
+	assert.Nil(t, err)
+	breaker.record(generation, false)
+	assert.Equal(t, CircuitOpen, breaker.State())
+
+	// changes are delivered in the order in which they happened
+	assert.Equal(t, []string{
+		"closed -> open",
+		"open -> half-open",
+		"half-open -> closed",
+		"closed -> open",
+		"open -> half-open",
+		"half-open -> open",
+	}, changes)
+}
+
+func TestCircuitBreaker_SlowCallback(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	var breaker *circuitBreaker
+	breaker = newCircuitBreaker(EndpointGroupUsers, &CircuitBreakerOptions{
+		FailureThreshold: 1,
+		OpenTimeout:      time.Hour,
+		OnStateChange: func(group EndpointGroup, from CircuitState, to CircuitState) {
+			// requests from the callback itself must not deadlock
+			_, err := breaker.allow()
+			assert.True(t, errors.Is(err, CircuitOpenError))
+			close(started)
+			<-release
+		},
+	})
+
+	generation, _ := breaker.allow()
+	done := make(chan struct{})
+	go func() {
+		breaker.record(generation, false)
+		close(done)
+	}()
+
+	// other requests are not blocked by the running callback
+	<-started
+	for i := 0; i < 3; i++ {
+		_, err := breaker.allow()
+		assert.True(t, errors.Is(err, CircuitOpenError))
+	}
+
+	close(release)
+	<-done
+}
+
+func TestCircuitBreaker_StaleOutcome(t *testing.T) {
+	now := time.Now()
+	breaker := newCircuitBreaker(EndpointGroupToken, &CircuitBreakerOptions{FailureThreshold: 1, OpenTimeout: time.Minute, SuccessThreshold: 2})
+	breaker.now = func() time.Time { return now }
+
+	slow, _ := breaker.allow()
+	failed, _ := breaker.allow()
+	breaker.record(failed, false)
+	assert.Equal(t, CircuitOpen, breaker.State())
+
+	now = now.Add(time.Minute)
+	trial, err := breaker.allow()
+	assert.Nil(t, err)
+
+	// the request sent before the circuit opened neither counts as trial nor frees a trial slot
+	breaker.record(slow, true)
+	breaker.release(slow)
+	assert.Equal(t, CircuitHalfOpen, breaker.State())
+	assert.Equal(t, 1, breaker.inFlight)
+
+	breaker.record(trial, true)
+	assert.Equal(t, CircuitHalfOpen, breaker.State())
+}
+
+func TestCidaasUtils_JWTInterceptor_CircuitOpen(t *testing.T) {
+	utils := mockUtils()
+	utils.options.CircuitBreaker = &CircuitBreakerOptions{}
+	breaker := utils.circuitBreaker(EndpointGroupJWKS)
+	breaker.mu.Lock()
+	breaker.setState(CircuitOpen)
+	breaker.mu.Unlock()
+
+	unknownKey := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"iss": "https://example.com"})
+	unknownKey.Header["kid"] = "unknown"
+	token, _ := unknownKey.SignedString([]byte("secret"))
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "", nil)
+	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
+
+	utils.JWTInterceptor(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.WriteHeader(200)
+	})).ServeHTTP(w, req)
+
+	assert.Equal(t, 503, w.Result().StatusCode)
+
+	// known keys can still be validated
+	_, err := utils.ValidateJWT(testToken)
+	assert.Nil(t, err)
+}
@@ -0,0 +1,603 @@
+package cidaasutils
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// BulkFormat is the file format used by ExportUsers and ImportUsers.
+type BulkFormat string
+
+const (
+	// BulkFormatJSONLines writes or reads one UserInfo json object per line.
+	BulkFormatJSONLines BulkFormat = "jsonl"
+	// BulkFormatCSV writes or reads a csv file with a header row, see csvColumns.
+	// Roles are separated by ";" and custom fields use "custom_fields.<name>" columns.
+	// Custom fields which are no strings are written as json and imported with the type of the current value.
+	BulkFormatCSV BulkFormat = "csv"
+)
+
+// csvColumns are the fixed columns of the csv format.
+var csvColumns = []string{"sub", "email", "given_name", "family_name", "mobile_number", "locale", "provider", "roles"}
+
+// csvCustomFieldPrefix is the prefix of csv columns containing custom fields.
+const csvCustomFieldPrefix = "custom_fields."
+
+// defaultBulkConcurrency is the number of rows imported in parallel if not configured otherwise.
+const defaultBulkConcurrency = 4
+
+// BulkOptions configure ExportUsers and ImportUsers.
+type BulkOptions struct {
+	// Concurrency is the number of rows imported in parallel. Default is 4.
+	Concurrency int
+
+	// Checkpoint is the number of rows (or exported users) which were already processed
+	// in a previous run and are skipped.
+	// Resumed csv exports are written without header so they can be appended to the previous output.
+	Checkpoint int
+
+	// OnCheckpoint is called with the number of rows which are completely processed,
+	// which can be used as Checkpoint to resume after a failure.
+	OnCheckpoint func(checkpoint int)
+
+	// DryRun only looks up the users. Nothing is written to Cidaas or, for exports, to the writer.
+	DryRun bool
+
+	// Report receives one BulkRowResult json object per processed row.
+	Report io.Writer
+
+	// Query selects the exported users. Default is all users.
+	Query *UserSearchQuery
+
+	// CustomFields are exported as csv columns.
+	CustomFields []string
+}
+
+// Actions of a BulkRowResult.
+const (
+	BulkActionExported  = "exported"
+	BulkActionCreated   = "created"
+	BulkActionUpdated   = "updated"
+	BulkActionUnchanged = "unchanged"
+	BulkActionFailed    = "failed"
+)
+
+// BulkRowResult is the result of one row written to BulkOptions.Report.
+type BulkRowResult struct {
+	// Row is the number of the row, starting with 1 and not counting the csv header.
+	Row    int    `json:"row"`
+	Sub    string `json:"sub,omitempty"`
+	Email  string `json:"email,omitempty"`
+	Action string `json:"action"`
+	DryRun bool   `json:"dry_run,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// BulkResult sums up all rows of an export or import.
+type BulkResult struct {
+	Processed int
+	Exported  int
+	Created   int
+	Updated   int
+	Unchanged int
+	Failed    int
+}
+
+func (r *BulkResult) add(row *BulkRowResult) {
+	r.Processed++
+	switch row.Action {
+	case BulkActionExported:
+		r.Exported++
+	case BulkActionCreated:
+		r.Created++
+	case BulkActionUpdated:
+		r.Updated++
+	case BulkActionUnchanged:
+		r.Unchanged++
+	case BulkActionFailed:
+		r.Failed++
+	}
+}
+
+func (o *BulkOptions) report(row *BulkRowResult) error {
+	if o.Report == nil {
+		return nil
+	}
+	data, err := json.Marshal(row)
+	if err != nil {
+		return err
+	}
+	_, err = o.Report.Write(append(data, '\n'))
+	return err
+}
+
+func (o *BulkOptions) checkpoint(checkpoint int) {
+	if o.OnCheckpoint != nil {
+		o.OnCheckpoint(checkpoint)
+	}
+}
+
+// ExportUsers streams all users matching opts.Query to w.
+// Users are fetched page by page, a checkpoint is reported after every written user.
+func (u *CidaasUtils) ExportUsers(ctx context.Context, w io.Writer, format BulkFormat, opts *BulkOptions) (*BulkResult, error) {
+	if opts == nil {
+		opts = &BulkOptions{}
+	}
+
+	encode, flush, err := newUserEncoder(w, format, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	it := u.SearchUsers(ctx, opts.Query)
+	it.offset = opts.Checkpoint
+
+	result := &BulkResult{}
+	row := opts.Checkpoint
+	for it.Next() {
+		row++
+		user := it.User()
+		rowResult := &BulkRowResult{Row: row, Sub: user.Identity.Sub, Email: user.Identity.Email, Action: BulkActionExported, DryRun: opts.DryRun}
+
+		if !opts.DryRun {
+			if err := encode(user); err != nil {
+				return result, err
+			}
+			if err := flush(); err != nil {
+				return result, err
+			}
+		}
+
+		result.add(rowResult)
+		if err := opts.report(rowResult); err != nil {
+			return result, err
+		}
+		opts.checkpoint(row)
+	}
+
+	return result, it.Err()
+}
+
+// newUserEncoder returns functions to write a user in the given format and to flush the writer.
+func newUserEncoder(w io.Writer, format BulkFormat, opts *BulkOptions) (func(user *UserInfo) error, func() error, error) {
+	switch format {
+	case BulkFormatJSONLines:
+		encoder := json.NewEncoder(w)
+		encode := func(user *UserInfo) error {
+			return encoder.Encode(user)
+		}
+		return encode, func() error { return nil }, nil
+	case BulkFormatCSV:
+		writer := csv.NewWriter(w)
+		header := append([]string{}, csvColumns...)
+		for _, field := range opts.CustomFields {
+			header = append(header, csvCustomFieldPrefix+field)
+		}
+		// a resumed export continues the previous output which already has a header
+		headerWritten := opts.Checkpoint > 0
+
+		encode := func(user *UserInfo) error {
+			if !headerWritten {
+				headerWritten = true
+				if err := writer.Write(header); err != nil {
+					return err
+				}
+			}
+			return writer.Write(userToCSV(user, opts.CustomFields))
+		}
+		flush := func() error {
+			writer.Flush()
+			return writer.Error()
+		}
+		return encode, flush, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown bulk format %q", format)
+	}
+}
+
+func userToCSV(user *UserInfo, customFields []string) []string {
+	record := []string{
+		user.Identity.Sub,
+		user.Identity.Email,
+		user.Identity.GivenName,
+		user.Identity.FamilyName,
+		user.Identity.MobileNumber,
+		user.Identity.Locale,
+		user.Identity.Provider,
+		strings.Join(user.Roles, ";"),
+	}
+	for _, name := range customFields {
+		value := ""
+		if field, ok := user.CustomFields[name]; ok && field.Value != nil {
+			value = csvCellValue(field.Value)
+		}
+		record = append(record, value)
+	}
+	return record
+}
+
+// csvCellValue returns the csv cell of a custom field value, strings as they are and all other values as json.
+func csvCellValue(value interface{}) string {
+	if text, ok := value.(string); ok {
+		return text
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(data)
+}
+
+// bulkRow is a parsed row of an import.
+type bulkRow struct {
+	row  int
+	user *UserInfo
+	err  error
+	// untyped is set for csv rows, whose custom fields are all strings.
+	untyped bool
+}
+
+// ImportUsers creates or updates all users read from r.
+// Existing users are looked up by sub or, if the row has no sub, by email and only changed fields are updated.
+// If the row has roles, the user gets exactly these roles, missing ones are added and others are removed.
+// New users are created with an invitation since the import contains no passwords.
+// Rows are processed with opts.Concurrency workers, failed rows are reported and do not stop the import.
+func (u *CidaasUtils) ImportUsers(ctx context.Context, r io.Reader, format BulkFormat, opts *BulkOptions) (*BulkResult, error) {
+	if opts == nil {
+		opts = &BulkOptions{}
+	}
+	concurrency := opts.Concurrency
+	if concurrency <= 0 {
+		concurrency = defaultBulkConcurrency
+	}
+
+	decode, err := newUserDecoder(r, format)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	rows := make(chan *bulkRow)
+	results := make(chan *BulkRowResult)
+	readErr := make(chan error, 1)
+
+	go func() {
+		defer close(rows)
+		for row := 1; ; row++ {
+			user, err := decode()
+			if errors.Is(err, io.EOF) {
+				readErr <- nil
+				return
+			}
+			var parseError *csv.ParseError
+			if err != nil && !errors.As(err, &parseError) && !isJSONSyntaxError(err) {
+				readErr <- err
+				return
+			}
+			if row <= opts.Checkpoint {
+				continue
+			}
+
+			select {
+			case rows <- &bulkRow{row: row, user: user, err: err, untyped: format == BulkFormatCSV}:
+			case <-ctx.Done():
+				readErr <- ctx.Err()
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for row := range rows {
+				results <- u.importRow(ctx, row, opts.DryRun)
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	// collect the results, the checkpoint only advances over contiguous finished rows
+	result := &BulkResult{}
+	finished := map[int]bool{}
+	checkpoint := opts.Checkpoint
+	var reportErr error
+	for rowResult := range results {
+		result.add(rowResult)
+		if reportErr == nil {
+			if reportErr = opts.report(rowResult); reportErr != nil {
+				cancel()
+			}
+		}
+
+		finished[rowResult.Row] = true
+		advanced := false
+		for finished[checkpoint+1] {
+			delete(finished, checkpoint+1)
+			checkpoint++
+			advanced = true
+		}
+		if advanced && reportErr == nil {
+			opts.checkpoint(checkpoint)
+		}
+	}
+
+	if reportErr != nil {
+		return result, reportErr
+	}
+	return result, <-readErr
+}
+
+func isJSONSyntaxError(err error) bool {
+	var syntaxError *json.SyntaxError
+	var typeError *json.UnmarshalTypeError
+	return errors.As(err, &syntaxError) || errors.As(err, &typeError)
+}
+
+// importRow creates or updates the user of the row.
+func (u *CidaasUtils) importRow(ctx context.Context, row *bulkRow, dryRun bool) *BulkRowResult {
+	result := &BulkRowResult{Row: row.row, DryRun: dryRun}
+	fail := func(err error) *BulkRowResult {
+		result.Action = BulkActionFailed
+		result.Error = err.Error()
+		return result
+	}
+
+	if row.err != nil {
+		return fail(row.err)
+	}
+	if err := ctx.Err(); err != nil {
+		return fail(err)
+	}
+
+	desired := row.user
+	result.Sub = desired.Identity.Sub
+	result.Email = desired.Identity.Email
+
+	current, err := u.findImportedUser(ctx, desired)
+	if err != nil {
+		return fail(err)
+	}
+
+	if current == nil {
+		result.Action = BulkActionCreated
+		if dryRun {
+			return result
+		}
+
+		sub, err := u.CreateUser(ctx, &UserCreateRequest{
+			Email:        desired.Identity.Email,
+			MobileNumber: desired.Identity.MobileNumber,
+			GivenName:    desired.Identity.GivenName,
+			FamilyName:   desired.Identity.FamilyName,
+			Locale:       desired.Identity.Locale,
+			Provider:     desired.Identity.Provider,
+			InviteUser:   true,
+			Roles:        desired.Roles,
+			CustomFields: desired.CustomFields,
+		})
+		if err != nil {
+			return fail(err)
+		}
+		result.Sub = sub
+		return result
+	}
+
+	result.Sub = current.Identity.Sub
+	if row.untyped {
+		desired = withCurrentCustomFieldTypes(current, desired)
+	}
+	update := DiffUserInfo(current, mergeImportedUser(current, desired))
+	addedRoles, removedRoles := diffRoles(current.Roles, desired.Roles)
+	if update == nil && len(addedRoles) == 0 && len(removedRoles) == 0 {
+		result.Action = BulkActionUnchanged
+		return result
+	}
+
+	result.Action = BulkActionUpdated
+	if dryRun {
+		return result
+	}
+	if update != nil {
+		if err := u.updateUserProfile(ctx, current.Identity.Sub, update); err != nil {
+			return fail(err)
+		}
+	}
+	for _, role := range addedRoles {
+		if err := u.AddUserRole(ctx, current.Identity.Sub, role); err != nil {
+			return fail(err)
+		}
+	}
+	for _, role := range removedRoles {
+		if err := u.RemoveUserRole(ctx, current.Identity.Sub, role); err != nil {
+			return fail(err)
+		}
+	}
+	return result
+}
+
+// diffRoles returns the roles which have to be added and removed to get from current to desired.
+// If desired is nil, the roles are not managed and nothing changes.
+func diffRoles(current []string, desired []string) ([]string, []string) {
+	if desired == nil {
+		return nil, nil
+	}
+
+	var added, removed []string
+	for _, role := range desired {
+		if !includesString(current, role) && !includesString(added, role) {
+			added = append(added, role)
+		}
+	}
+	for _, role := range current {
+		if !includesString(desired, role) {
+			removed = append(removed, role)
+		}
+	}
+	return added, removed
+}
+
+// findImportedUser returns the existing user by sub or email, or nil if there is none.
+func (u *CidaasUtils) findImportedUser(ctx context.Context, desired *UserInfo) (*UserInfo, error) {
+	if desired.Identity.Sub != "" {
+		current, err := u.fetchUserProfile(ctx, desired.Identity.Sub)
+		if IsNotFound(err) {
+			return nil, nil
+		}
+		return current, err
+	}
+
+	if desired.Identity.Email == "" {
+		return nil, nil
+	}
+
+	it := u.SearchUsers(ctx, &UserSearchQuery{Email: desired.Identity.Email, PageSize: 1})
+	if it.Next() {
+		return it.User(), nil
+	}
+	return nil, it.Err()
+}
+
+// mergeImportedUser returns current with all non-empty fields of the imported user.
+// Custom fields which are not part of the import are kept.
+func mergeImportedUser(current *UserInfo, imported *UserInfo) *UserInfo {
+	merged := *current
+	merge := func(target *string, value string) {
+		if value != "" {
+			*target = value
+		}
+	}
+	merge(&merged.Identity.Email, imported.Identity.Email)
+	merge(&merged.Identity.GivenName, imported.Identity.GivenName)
+	merge(&merged.Identity.FamilyName, imported.Identity.FamilyName)
+	merge(&merged.Identity.MobileNumber, imported.Identity.MobileNumber)
+	merge(&merged.Identity.Locale, imported.Identity.Locale)
+	merge(&merged.Identity.Provider, imported.Identity.Provider)
+
+	merged.CustomFields = map[string]CustomField{}
+	for key, field := range current.CustomFields {
+		merged.CustomFields[key] = field
+	}
+	for key, field := range imported.CustomFields {
+		merged.CustomFields[key] = field
+	}
+	return &merged
+}
+
+// withCurrentCustomFieldTypes returns the imported user with all custom fields which can be
+// converted to the type of the current value converted, e.g. "15" to 15 for a number field.
+func withCurrentCustomFieldTypes(current *UserInfo, imported *UserInfo) *UserInfo {
+	result := *imported
+	result.CustomFields = map[string]CustomField{}
+	for key, field := range imported.CustomFields {
+		result.CustomFields[key] = field
+
+		cell, ok := field.Value.(string)
+		currentValue := current.CustomFields[key].Value
+		if _, currentIsString := currentValue.(string); !ok || currentValue == nil || currentIsString {
+			continue
+		}
+
+		var parsed interface{}
+		if err := json.Unmarshal([]byte(cell), &parsed); err == nil && reflect.TypeOf(parsed) == reflect.TypeOf(currentValue) {
+			result.CustomFields[key] = CustomField{Value: parsed}
+		}
+	}
+	return &result
+}
+
+// newUserDecoder returns a function which reads the next user and io.EOF at the end.
+func newUserDecoder(r io.Reader, format BulkFormat) (func() (*UserInfo, error), error) {
+	switch format {
+	case BulkFormatJSONLines:
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		return func() (*UserInfo, error) {
+			for scanner.Scan() {
+				line := strings.TrimSpace(scanner.Text())
+				if line == "" {
+					continue
+				}
+				var user UserInfo
+				if err := json.Unmarshal([]byte(line), &user); err != nil {
+					return nil, err
+				}
+				return &user, nil
+			}
+			if err := scanner.Err(); err != nil {
+				return nil, err
+			}
+			return nil, io.EOF
+		}, nil
+	case BulkFormatCSV:
+		reader := csv.NewReader(r)
+		reader.FieldsPerRecord = -1
+		var header []string
+		return func() (*UserInfo, error) {
+			if header == nil {
+				var err error
+				if header, err = reader.Read(); err != nil {
+					return nil, err
+				}
+			}
+			record, err := reader.Read()
+			if err != nil {
+				return nil, err
+			}
+			return userFromCSV(header, record), nil
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown bulk format %q", format)
+	}
+}
+
+func userFromCSV(header []string, record []string) *UserInfo {
+	user := &UserInfo{CustomFields: map[string]CustomField{}}
+	for i, column := range header {
+		if i >= len(record) {
+			break
+		}
+		value := record[i]
+
+		switch column {
+		case "sub":
+			user.Identity.Sub = value
+		case "email":
+			user.Identity.Email = value
+		case "given_name":
+			user.Identity.GivenName = value
+		case "family_name":
+			user.Identity.FamilyName = value
+		case "mobile_number":
+			user.Identity.MobileNumber = value
+		case "locale":
+			user.Identity.Locale = value
+		case "provider":
+			user.Identity.Provider = value
+		case "roles":
+			if value != "" {
+				user.Roles = strings.Split(value, ";")
+			}
+		default:
+			if strings.HasPrefix(column, csvCustomFieldPrefix) && value != "" {
+				user.CustomFields[strings.TrimPrefix(column, csvCustomFieldPrefix)] = CustomField{Value: value}
+			}
+		}
+	}
+	return user
+}
@@ -0,0 +1,215 @@
+package cidaasutils
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func mockExportUtils(t *testing.T) (*CidaasUtils, func()) {
+	utils, server := mockAdminUtils(func(writer http.ResponseWriter, request *http.Request) {
+		var body map[string]interface{}
+		json.NewDecoder(request.Body).Decode(&body)
+		offset := int(body["offset"].(float64))
+		limit := int(body["limit"].(float64))
+
+		var users []string
+		for i := offset; i < offset+limit && i < 3; i++ {
+			users = append(users, fmt.Sprintf(`{"identity":{"sub":"sub-%d","email":"user%d@example.com"},"roles":["A","B"],"customFields":{"plan":{"value":"pro"}}}`, i, i))
+		}
+		writer.Write([]byte(fmt.Sprintf(`{"success":true,"status":200,"total":3,"data":[%s]}`, strings.Join(users, ","))))
+	})
+	return utils, server.Close
+}
+
+func TestCidaasUtils_ExportUsers_JSONLines(t *testing.T) {
+	utils, closeServer := mockExportUtils(t)
+	defer closeServer()
+
+	var output, report bytes.Buffer
+	var checkpoints []int
+	result, err := utils.ExportUsers(context.Background(), &output, BulkFormatJSONLines, &BulkOptions{
+		Checkpoint:   1,
+		Report:       &report,
+		OnCheckpoint: func(checkpoint int) { checkpoints = append(checkpoints, checkpoint) },
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, &BulkResult{Processed: 2, Exported: 2}, result)
+	assert.Equal(t, []int{2, 3}, checkpoints)
+
+	lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+	assert.Len(t, lines, 2)
+	var user UserInfo
+	assert.Nil(t, json.Unmarshal([]byte(lines[0]), &user))
+	assert.Equal(t, "sub-1", user.Identity.Sub)
+
+	assert.Contains(t, report.String(), `{"row":2,"sub":"sub-1","email":"user1@example.com","action":"exported"}`)
+}
+
+func TestCidaasUtils_ExportUsers_CSV(t *testing.T) {
+	utils, closeServer := mockExportUtils(t)
+	defer closeServer()
+
+	var output bytes.Buffer
+	_, err := utils.ExportUsers(context.Background(), &output, BulkFormatCSV, &BulkOptions{
+		Query:        &UserSearchQuery{PageSize: 2},
+		CustomFields: []string{"plan"},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "sub,email,given_name,family_name,mobile_number,locale,provider,roles,custom_fields.plan\n"+
+		"sub-0,user0@example.com,,,,,,A;B,pro\n"+
+		"sub-1,user1@example.com,,,,,,A;B,pro\n"+
+		"sub-2,user2@example.com,,,,,,A;B,pro\n", output.String())
+
+	// a resumed export can be appended to the previous output
+	output.Reset()
+	_, err = utils.ExportUsers(context.Background(), &output, BulkFormatCSV, &BulkOptions{Checkpoint: 2})
+	assert.Nil(t, err)
+	assert.Equal(t, "sub-2,user2@example.com,,,,,,A;B\n", output.String())
+
+	output.Reset()
+	result, err := utils.ExportUsers(context.Background(), &output, BulkFormatCSV, &BulkOptions{DryRun: true})
+	assert.Nil(t, err)
+	assert.Equal(t, 3, result.Exported)
+	assert.Equal(t, "", output.String())
+}
+
+func TestCidaasUtils_ImportUsers(t *testing.T) {
+	var mu sync.Mutex
+	var created, updated, roles []string
+	utils, server := mockAdminUtils(func(writer http.ResponseWriter, request *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		var body map[string]interface{}
+		json.NewDecoder(request.Body).Decode(&body)
+
+		switch {
+		case request.URL.Path == "/"+userSearchEndpoint:
+			if body["email"] == "existing@example.com" {
+				writer.Write([]byte(`{"success":true,"total":1,"data":[{"identity":{"sub":"existing","email":"existing@example.com","given_name":"Old"},"customFields":{"plan":{"value":"free"}}}]}`))
+				return
+			}
+			writer.Write([]byte(`{"success":true,"total":0,"data":[]}`))
+		case request.URL.Path == "/users-srv/internal/userinfo/profile/unchanged":
+			writer.Write([]byte(`{"success":true,"data":{"identity":{"sub":"unchanged","given_name":"Same"},"roles":["USER"]}}`))
+		case request.URL.Path == "/users-srv/internal/userinfo/profile/roles":
+			writer.Write([]byte(`{"success":true,"data":{"identity":{"sub":"roles","given_name":"Same"},"roles":["USER","OLD"]}}`))
+		case strings.HasPrefix(request.URL.Path, "/users-srv/user/roles/roles"):
+			roles = append(roles, fmt.Sprintf("%s %s %v", request.Method, request.URL.Path, body["role"]))
+			writer.WriteHeader(204)
+		case request.Method == "POST" && request.URL.Path == "/"+userCreateEndpoint:
+			created = append(created, body["email"].(string))
+			assert.Equal(t, true, body["invite_user"])
+			writer.Write([]byte(`{"success":true,"data":{"sub":"new-sub"}}`))
+		case request.Method == "PUT":
+			data, _ := json.Marshal(body)
+			updated = append(updated, request.URL.Path+" "+string(data))
+			writer.Write([]byte(`{"success":true}`))
+		default:
+			t.Errorf("unexpected request %s %s", request.Method, request.URL.Path)
+		}
+	})
+	defer server.Close()
+
+	input := "sub,email,given_name,roles,custom_fields.plan\n" +
+		"skipped,skipped@example.com,,,\n" +
+		",new@example.com,New,USER;ADMIN,\n" +
+		",existing@example.com,New,,pro\n" +
+		"unchanged,,Same,USER,\n" +
+		",,,,\n" +
+		"roles,,Same,USER;ADMIN,\n"
+
+	var report bytes.Buffer
+	var checkpoints []int
+	result, err := utils.ImportUsers(context.Background(), strings.NewReader(input), BulkFormatCSV, &BulkOptions{
+		Concurrency:  2,
+		Checkpoint:   1,
+		Report:       &report,
+		OnCheckpoint: func(checkpoint int) { checkpoints = append(checkpoints, checkpoint) },
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, &BulkResult{Processed: 5, Created: 1, Updated: 2, Unchanged: 1, Failed: 1}, result)
+	assert.Equal(t, 6, checkpoints[len(checkpoints)-1])
+
+	assert.Equal(t, []string{"new@example.com"}, created)
+	assert.Equal(t, []string{`/users-srv/user/existing {"customFields":{"plan":{"value":"pro"}},"given_name":"New"}`}, updated)
+	// the roles of existing users are changed to the roles of the row
+	assert.Equal(t, []string{"POST /users-srv/user/roles/roles ADMIN", "DELETE /users-srv/user/roles/roles/OLD <nil>"}, roles)
+
+	lines := strings.Split(strings.TrimSpace(report.String()), "\n")
+	assert.Len(t, lines, 5)
+	assert.Contains(t, report.String(), `{"row":5,"action":"failed","error":"user validation failed: email is required"}`)
+}
+
+func TestCidaasUtils_ImportUsers_DryRun(t *testing.T) {
+	utils, server := mockAdminUtils(func(writer http.ResponseWriter, request *http.Request) {
+		if request.Method != "POST" || request.URL.Path != "/"+userSearchEndpoint {
+			t.Errorf("unexpected request %s %s", request.Method, request.URL.Path)
+		}
+		writer.Write([]byte(`{"success":true,"total":0,"data":[]}`))
+	})
+	defer server.Close()
+
+	input := `{"identity":{"email":"new@example.com"}}
+not json
+`
+	var report bytes.Buffer
+	result, err := utils.ImportUsers(context.Background(), strings.NewReader(input), BulkFormatJSONLines, &BulkOptions{DryRun: true, Report: &report})
+	assert.Nil(t, err)
+	assert.Equal(t, &BulkResult{Processed: 2, Created: 1, Failed: 1}, result)
+	assert.Contains(t, report.String(), `{"row":1,"email":"new@example.com","action":"created","dry_run":true}`)
+}
+
+func TestCidaasUtils_ExportImportUsers_CSVRoundTrip(t *testing.T) {
+	const user = `{"identity":{"sub":"sub-0","email":"user0@example.com"},"roles":["A"],` +
+		`"customFields":{"customer_id":{"value":15},"newsletter":{"value":true},"zip":{"value":"00123"},"tags":{"value":["a","b"]}}}`
+	var updates []string
+	utils, server := mockAdminUtils(func(writer http.ResponseWriter, request *http.Request) {
+		switch {
+		case request.URL.Path == "/"+userSearchEndpoint:
+			writer.Write([]byte(`{"success":true,"total":1,"data":[` + user + `]}`))
+		case request.URL.Path == "/users-srv/internal/userinfo/profile/sub-0":
+			writer.Write([]byte(`{"success":true,"data":` + user + `}`))
+		default:
+			updates = append(updates, request.Method+" "+request.URL.Path)
+			writer.Write([]byte(`{"success":true}`))
+		}
+	})
+	defer server.Close()
+	ctx := context.Background()
+
+	var output bytes.Buffer
+	_, err := utils.ExportUsers(ctx, &output, BulkFormatCSV, &BulkOptions{CustomFields: []string{"customer_id", "newsletter", "zip", "tags"}})
+	assert.Nil(t, err)
+	assert.Equal(t, "sub,email,given_name,family_name,mobile_number,locale,provider,roles,"+
+		"custom_fields.customer_id,custom_fields.newsletter,custom_fields.zip,custom_fields.tags\n"+
+		`sub-0,user0@example.com,,,,,,A,15,true,00123,"[""a"",""b""]"`+"\n", output.String())
+
+	// importing the export again changes nothing
+	result, err := utils.ImportUsers(ctx, &output, BulkFormatCSV, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, &BulkResult{Processed: 1, Unchanged: 1}, result)
+	assert.Empty(t, updates)
+}
+
+func TestWithCurrentCustomFieldTypes(t *testing.T) {
+	current := &UserInfo{CustomFields: map[string]CustomField{"customer_id": {Value: 15.0}, "zip": {Value: "00123"}}}
+	imported := &UserInfo{CustomFields: map[string]CustomField{"customer_id": {Value: "16"}, "zip": {Value: "00124"}, "new": {Value: "1"}}}
+
+	result := withCurrentCustomFieldTypes(current, imported)
+	assert.Equal(t, 16.0, result.CustomFields["customer_id"].Value)
+	assert.Equal(t, "00124", result.CustomFields["zip"].Value)
+	assert.Equal(t, "1", result.CustomFields["new"].Value)
+
+	// values which do not fit the current type are imported as they are
+	imported.CustomFields["customer_id"] = CustomField{Value: "unknown"}
+	assert.Equal(t, "unknown", withCurrentCustomFieldTypes(current, imported).CustomFields["customer_id"].Value)
+}
@@ -0,0 +1,276 @@
+package cidaasutils
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+// ProfileCacheBackend stores the cached user profiles.
+// Implement it to share the cache between several instances, e.g. with Redis.
+type ProfileCacheBackend interface {
+	// Get returns the cached profile and whether it was found.
+	Get(ctx context.Context, sub string) (*UserInfo, bool, error)
+	// Set caches the profile for the given time.
+	Set(ctx context.Context, sub string, info *UserInfo, ttl time.Duration) error
+	// Delete removes the profile from the cache.
+	Delete(ctx context.Context, sub string) error
+}
+
+// ProfileCacheOptions configure the read-through cache of GetUserProfileInternally.
+type ProfileCacheOptions struct {
+	// Time a profile is cached. Default is 5 minutes.
+	TTL time.Duration
+
+	// Maximum number of profiles kept by the default in-memory backend.
+	// The least recently used profile is evicted first. Default is 1000.
+	MaxSize int
+
+	// Backend stores the profiles. Default is a MemoryProfileCache with MaxSize entries.
+	Backend ProfileCacheBackend
+}
+
+// MemoryProfileCache is an in-memory LRU ProfileCacheBackend.
+type MemoryProfileCache struct {
+	maxSize int
+	now     func() time.Time
+
+	mu      sync.Mutex
+	entries *list.List
+	items   map[string]*list.Element
+}
+
+type memoryProfileCacheEntry struct {
+	sub       string
+	info      *UserInfo
+	expiresAt time.Time
+}
+
+// NewMemoryProfileCache creates a MemoryProfileCache which holds at most maxSize profiles.
+func NewMemoryProfileCache(maxSize int) *MemoryProfileCache {
+	return &MemoryProfileCache{
+		maxSize: maxSize,
+		now:     time.Now,
+		entries: list.New(),
+		items:   map[string]*list.Element{},
+	}
+}
+
+// Get implements ProfileCacheBackend.
+func (c *MemoryProfileCache) Get(_ context.Context, sub string) (*UserInfo, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.items[sub]
+	if !ok {
+		return nil, false, nil
+	}
+	entry := element.Value.(*memoryProfileCacheEntry)
+	if !c.now().Before(entry.expiresAt) {
+		c.remove(element)
+		return nil, false, nil
+	}
+	c.entries.MoveToFront(element)
+	return entry.info, true, nil
+}
+
+// Set implements ProfileCacheBackend.
+func (c *MemoryProfileCache) Set(_ context.Context, sub string, info *UserInfo, ttl time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry := &memoryProfileCacheEntry{sub: sub, info: info, expiresAt: c.now().Add(ttl)}
+	if element, ok := c.items[sub]; ok {
+		element.Value = entry
+		c.entries.MoveToFront(element)
+		return nil
+	}
+
+	c.items[sub] = c.entries.PushFront(entry)
+	for c.maxSize > 0 && c.entries.Len() > c.maxSize {
+		c.remove(c.entries.Back())
+	}
+	return nil
+}
+
+// Delete implements ProfileCacheBackend.
+func (c *MemoryProfileCache) Delete(_ context.Context, sub string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, ok := c.items[sub]; ok {
+		c.remove(element)
+	}
+	return nil
+}
+
+// Len returns the number of cached profiles, including expired ones which were not evicted yet.
+func (c *MemoryProfileCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.entries.Len()
+}
+
+// remove has to be called with the lock held.
+func (c *MemoryProfileCache) remove(element *list.Element) {
+	c.entries.Remove(element)
+	delete(c.items, element.Value.(*memoryProfileCacheEntry).sub)
+}
+
+// lookupGroup makes sure that concurrent lookups of the same key only hit Cidaas once
+// and that lookups which were invalidated while in flight do not end up in a cache.
+type lookupGroup struct {
+	mu    sync.Mutex
+	calls map[string]*lookupCall
+}
+
+// lookupCall is a lookup in flight which other callers wait for.
+type lookupCall struct {
+	done  chan struct{}
+	value interface{}
+	err   error
+	// stale is set if the key was invalidated while the lookup was in flight.
+	stale bool
+}
+
+// do returns the result of fetch for the key. Concurrent lookups of the same key share one fetch,
+// which uses the context of the first caller. A successful result is passed to store, unless the key
+// was invalidated in the meantime. If the invalidation arrived while store was running, remove is called.
+func (g *lookupGroup) do(ctx context.Context, key string, fetch func() (interface{}, error), store func(value interface{}), remove func()) (interface{}, error) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = map[string]*lookupCall{}
+	}
+	if call, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		select {
+		case <-call.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		return call.value, call.err
+	}
+	call := &lookupCall{done: make(chan struct{})}
+	g.calls[key] = call
+	g.mu.Unlock()
+
+	call.value, call.err = fetch()
+	close(call.done)
+
+	// the call stays registered until the result is stored, so invalidations in between mark it stale
+	g.mu.Lock()
+	stored := call.err == nil && !call.stale
+	g.mu.Unlock()
+	if stored {
+		store(call.value)
+	}
+
+	g.mu.Lock()
+	if g.calls[key] == call {
+		delete(g.calls, key)
+	}
+	stale := call.stale
+	g.mu.Unlock()
+	if stored && stale {
+		remove()
+	}
+
+	return call.value, call.err
+}
+
+// invalidate marks the lookup of the key in flight, if any, as stale.
+func (g *lookupGroup) invalidate(key string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if call, ok := g.calls[key]; ok {
+		call.stale = true
+		delete(g.calls, key)
+	}
+}
+
+// profileCache puts the backend in front of the profile lookups.
+type profileCache struct {
+	backend ProfileCacheBackend
+	ttl     time.Duration
+	lookups lookupGroup
+}
+
+func newProfileCache(options *ProfileCacheOptions) *profileCache {
+	ttl := options.TTL
+	if ttl <= 0 {
+		ttl = 5 * time.Minute
+	}
+	backend := options.Backend
+	if backend == nil {
+		maxSize := options.MaxSize
+		if maxSize <= 0 {
+			maxSize = 1000
+		}
+		backend = NewMemoryProfileCache(maxSize)
+	}
+	return &profileCache{backend: backend, ttl: ttl}
+}
+
+// profileCache returns the cache or nil if caching is disabled.
+func (u *CidaasUtils) profileCache() *profileCache {
+	if u.options.ProfileCache == nil {
+		return nil
+	}
+
+	u.profileCacheMu.Lock()
+	defer u.profileCacheMu.Unlock()
+	if u.profiles == nil {
+		u.profiles = newProfileCache(u.options.ProfileCache)
+	}
+	return u.profiles
+}
+
+// cachedUserProfile returns the profile from the cache or fetches it from Cidaas.
+func (u *CidaasUtils) cachedUserProfile(ctx context.Context, cache *profileCache, sub string) (*UserInfo, error) {
+	info, ok, err := cache.backend.Get(ctx, sub)
+	if err != nil {
+		u.logger().Warn("could not read user profile from cache", "sub", sub, "error", err)
+	} else if ok {
+		return copyUserInfo(info)
+	}
+
+	value, err := cache.lookups.do(ctx, sub, func() (interface{}, error) {
+		return u.fetchUserProfile(ctx, sub)
+	}, func(value interface{}) {
+		if err := cache.backend.Set(ctx, sub, value.(*UserInfo), cache.ttl); err != nil {
+			u.logger().Warn("could not write user profile to cache", "sub", sub, "error", err)
+		}
+	}, func() {
+		if err := cache.backend.Delete(ctx, sub); err != nil {
+			u.logger().Warn("could not invalidate cached user profile", "sub", sub, "error", err)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return copyUserInfo(value.(*UserInfo))
+}
+
+// InvalidateUserProfile removes the profile of the user from the cache.
+// It is called automatically by all methods of this library which change the profile,
+// call it yourself if the profile was changed elsewhere.
+func (u *CidaasUtils) InvalidateUserProfile(ctx context.Context, sub string) error {
+	cache := u.profileCache()
+	if cache == nil {
+		return nil
+	}
+
+	cache.lookups.invalidate(sub)
+	return cache.backend.Delete(ctx, sub)
+}
+
+// invalidateUserProfiles invalidates the profiles after a change and only logs failures,
+// the change itself was successful.
+func (u *CidaasUtils) invalidateUserProfiles(ctx context.Context, subs ...string) {
+	for _, sub := range subs {
+		if err := u.InvalidateUserProfile(ctx, sub); err != nil {
+			u.logger().Warn("could not invalidate cached user profile", "sub", sub, "error", err)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package cidaasutils
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryProfileCache(t *testing.T) {
+	now := time.Now()
+	cache := NewMemoryProfileCache(2)
+	cache.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	cache.Set(ctx, "a", &UserInfo{Roles: []string{"A"}}, time.Minute)
+	cache.Set(ctx, "b", &UserInfo{Roles: []string{"B"}}, time.Minute)
+	_, ok, _ := cache.Get(ctx, "a")
+	assert.True(t, ok)
+
+	// b is the least recently used entry
+	cache.Set(ctx, "c", &UserInfo{Roles: []string{"C"}}, time.Minute)
+	_, ok, _ = cache.Get(ctx, "b")
+	assert.False(t, ok)
+	info, ok, _ := cache.Get(ctx, "a")
+	assert.True(t, ok)
+	assert.Equal(t, []string{"A"}, info.Roles)
+	assert.Equal(t, 2, cache.Len())
+
+	now = now.Add(time.Minute)
+	_, ok, _ = cache.Get(ctx, "a")
+	assert.False(t, ok)
+	assert.Equal(t, 1, cache.Len())
+
+	cache.Delete(ctx, "c")
+	assert.Equal(t, 0, cache.Len())
+}
+
+func TestCidaasUtils_ProfileCache(t *testing.T) {
+	var lookups int32
+	utils, server := mockAdminUtils(func(writer http.ResponseWriter, request *http.Request) {
+		switch request.Method {
+		case "GET":
+			atomic.AddInt32(&lookups, 1)
+			writer.Write([]byte(`{"success":true,"data":{"identity":{"sub":"test","given_name":"Test"}}}`))
+		case "PUT":
+			writer.Write([]byte(`{"success":true}`))
+		case "POST":
+			writer.Write([]byte(`{"success":true,"data":{"userStatus":"LOCKED"}}`))
+		}
+	})
+	defer server.Close()
+	utils.options.ProfileCache = &ProfileCacheOptions{TTL: time.Minute}
+
+	info, err := utils.GetUserProfileInternally("test")
+	assert.Nil(t, err)
+	assert.Equal(t, "Test", info.Identity.GivenName)
+
+	// callers get a copy and can not modify the cached profile
+	info.Identity.GivenName = "Changed"
+	info, err = utils.GetUserProfileInternally("test")
+	assert.Nil(t, err)
+	assert.Equal(t, "Test", info.Identity.GivenName)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&lookups))
+
+	givenName := "Test"
+	err = utils.UpdateUserProfileInternally("test", &UserUpdateRequest{GivenName: &givenName})
+	assert.Nil(t, err)
+	_, err = utils.GetUserProfileInternally("test")
+	assert.Nil(t, err)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&lookups))
+
+	_, err = utils.LockUser(context.Background(), "test")
+	assert.Nil(t, err)
+	_, err = utils.GetUserProfileInternally("test")
+	assert.Nil(t, err)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&lookups))
+}
+
+func TestCidaasUtils_ProfileCache_Singleflight(t *testing.T) {
+	var lookups int32
+	release := make(chan struct{})
+	utils, server := mockAdminUtils(func(writer http.ResponseWriter, request *http.Request) {
+		atomic.AddInt32(&lookups, 1)
+		<-release
+		writer.Write([]byte(`{"success":true,"data":{"identity":{"sub":"test"}}}`))
+	})
+	defer server.Close()
+	utils.options.ProfileCache = &ProfileCacheOptions{}
+	// fetch the admin token before the lookups start
+	_, err := utils.GetMyAccessToken()
+	assert.Nil(t, err)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			info, err := utils.GetUserProfileInternally("test")
+			assert.Nil(t, err)
+			assert.Equal(t, "test", info.Identity.Sub)
+		}()
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&lookups))
+}
+
+func TestCidaasUtils_InvalidateUserProfile_InFlight(t *testing.T) {
+	utils, server := mockAdminUtils(func(writer http.ResponseWriter, request *http.Request) {
+		writer.Write([]byte(`{"success":true,"data":{"identity":{"sub":"test"}}}`))
+	})
+	defer server.Close()
+	utils.options.ProfileCache = &ProfileCacheOptions{}
+	cache := utils.profileCache()
+
+	// a lookup which started before the invalidation must not be cached
+	call := &lookupCall{done: make(chan struct{})}
+	cache.lookups.calls = map[string]*lookupCall{"test": call}
+	assert.Nil(t, utils.InvalidateUserProfile(context.Background(), "test"))
+	assert.True(t, call.stale)
+	assert.Empty(t, cache.lookups.calls)
+}
+
+// blockingProfileCache blocks in Set until release is closed.
+type blockingProfileCache struct {
+	*MemoryProfileCache
+	setting chan struct{}
+	release chan struct{}
+}
+
+func (c *blockingProfileCache) Set(ctx context.Context, sub string, info *UserInfo, ttl time.Duration) error {
+	close(c.setting)
+	<-c.release
+	return c.MemoryProfileCache.Set(ctx, sub, info, ttl)
+}
+
+func TestCidaasUtils_InvalidateUserProfile_WhileStoring(t *testing.T) {
+	var givenName atomic.Value
+	givenName.Store("Old")
+	utils, server := mockAdminUtils(func(writer http.ResponseWriter, request *http.Request) {
+		writer.Write([]byte(`{"success":true,"data":{"identity":{"sub":"test","given_name":"` + givenName.Load().(string) + `"}}}`))
+	})
+	defer server.Close()
+	backend := &blockingProfileCache{MemoryProfileCache: NewMemoryProfileCache(10), setting: make(chan struct{}), release: make(chan struct{})}
+	utils.options.ProfileCache = &ProfileCacheOptions{Backend: backend}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		info, err := utils.GetUserProfileInternally("test")
+		assert.Nil(t, err)
+		assert.Equal(t, "Old", info.Identity.GivenName)
+	}()
+
+	// the profile changes while the old one is written to the cache
+	<-backend.setting
+	givenName.Store("New")
+	assert.Nil(t, utils.InvalidateUserProfile(context.Background(), "test"))
+	close(backend.release)
+	<-done
+
+	_, ok, _ := backend.Get(context.Background(), "test")
+	assert.False(t, ok)
+}
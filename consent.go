@@ -0,0 +1,306 @@
+package cidaasutils
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ConsentRequiredError is returned if the user did not accept a required consent version.
+var ConsentRequiredError = errors.New("consent required")
+
+// ConsentStatus is the decision of a user about a consent.
+type ConsentStatus string
+
+const (
+	ConsentAccepted  ConsentStatus = "ACCEPTED"
+	ConsentWithdrawn ConsentStatus = "WITHDRAWN"
+)
+
+// Consent is a consent definition of the tenant, e.g. the terms of service.
+type Consent struct {
+	ID          string `json:"consentId"`
+	Name        string `json:"name"`
+	Version     string `json:"version"`
+	Description string `json:"description,omitempty"`
+	URL         string `json:"url,omitempty"`
+}
+
+// UserConsent is the decision of a user about a consent version.
+type UserConsent struct {
+	ConsentID   string        `json:"consentId"`
+	Version     string        `json:"version"`
+	Status      ConsentStatus `json:"status"`
+	UpdatedTime Timestamp     `json:"updatedTime"`
+}
+
+type ConsentsResponse struct {
+	Success bool      `json:"success"`
+	Status  int       `json:"status"`
+	Data    []Consent `json:"data"`
+}
+
+type UserConsentsResponse struct {
+	Success bool          `json:"success"`
+	Status  int           `json:"status"`
+	Data    []UserConsent `json:"data"`
+}
+
+// ConsentCacheOptions configure the cache of the consents checked by WithConsent and CheckConsents.
+type ConsentCacheOptions struct {
+	// Time the consents of a user are cached. Consents changed outside of this instance
+	// are only seen after this time. Default is 30 seconds.
+	TTL time.Duration
+
+	// Maximum number of users whose consents are cached. Default is 1000.
+	MaxSize int
+}
+
+// consentCache caches the consents per user.
+type consentCache struct {
+	ttl     time.Duration
+	maxSize int
+	now     func() time.Time
+	lookups lookupGroup
+
+	mu      sync.Mutex
+	entries map[string]consentCacheEntry
+}
+
+type consentCacheEntry struct {
+	consents  []UserConsent
+	expiresAt time.Time
+}
+
+func newConsentCache(options *ConsentCacheOptions) *consentCache {
+	ttl := options.TTL
+	if ttl <= 0 {
+		ttl = 30 * time.Second
+	}
+	maxSize := options.MaxSize
+	if maxSize <= 0 {
+		maxSize = 1000
+	}
+	return &consentCache{
+		ttl:     ttl,
+		maxSize: maxSize,
+		now:     time.Now,
+		entries: map[string]consentCacheEntry{},
+	}
+}
+
+// get returns the cached consents of the user and whether they were found.
+func (c *consentCache) get(sub string) ([]UserConsent, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[sub]
+	if !ok || !c.now().Before(entry.expiresAt) {
+		return nil, false
+	}
+	return entry.consents, true
+}
+
+// set caches the consents of the user.
+// If the cache is full, expired entries and then arbitrary entries are evicted.
+func (c *consentCache) set(sub string, consents []UserConsent) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	if _, ok := c.entries[sub]; !ok && len(c.entries) >= c.maxSize {
+		for key, entry := range c.entries {
+			if !now.Before(entry.expiresAt) {
+				delete(c.entries, key)
+			}
+		}
+		for key := range c.entries {
+			if len(c.entries) < c.maxSize {
+				break
+			}
+			delete(c.entries, key)
+		}
+	}
+	c.entries[sub] = consentCacheEntry{consents: consents, expiresAt: now.Add(c.ttl)}
+}
+
+// delete removes the consents of the user from the cache.
+func (c *consentCache) delete(sub string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, sub)
+}
+
+// consentCache returns the cache or nil if caching is disabled.
+func (u *CidaasUtils) consentCache() *consentCache {
+	if u.options.ConsentCache == nil {
+		return nil
+	}
+
+	u.consentCacheMu.Lock()
+	defer u.consentCacheMu.Unlock()
+	if u.consents == nil {
+		u.consents = newConsentCache(u.options.ConsentCache)
+	}
+	return u.consents
+}
+
+// cachedUserConsents returns the consents of the user from the cache or fetches them from Cidaas.
+// Callers get a copy and can not modify the cached consents.
+func (u *CidaasUtils) cachedUserConsents(ctx context.Context, sub string) ([]UserConsent, error) {
+	cache := u.consentCache()
+	if cache == nil {
+		return u.GetUserConsents(ctx, sub)
+	}
+
+	if consents, ok := cache.get(sub); ok {
+		return copyUserConsents(consents), nil
+	}
+
+	value, err := cache.lookups.do(ctx, sub, func() (interface{}, error) {
+		return u.GetUserConsents(ctx, sub)
+	}, func(value interface{}) {
+		cache.set(sub, value.([]UserConsent))
+	}, func() {
+		cache.delete(sub)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return copyUserConsents(value.([]UserConsent)), nil
+}
+
+func copyUserConsents(consents []UserConsent) []UserConsent {
+	return append([]UserConsent{}, consents...)
+}
+
+// invalidateUserConsents removes the consents of the user from the cache.
+func (u *CidaasUtils) invalidateUserConsents(sub string) {
+	cache := u.consentCache()
+	if cache == nil {
+		return
+	}
+
+	cache.lookups.invalidate(sub)
+	cache.delete(sub)
+}
+
+type userConsentRequest struct {
+	Version string        `json:"version,omitempty"`
+	Status  ConsentStatus `json:"status"`
+}
+
+// ListConsents returns all consent definitions of the tenant in their current version.
+func (u *CidaasUtils) ListConsents(ctx context.Context) ([]Consent, error) {
+	token, err := u.GetMyAccessToken()
+	if err != nil {
+		return nil, err
+	}
+
+	var result ConsentsResponse
+	err = u.doRequest(&RequestInit{Operation: "ListConsents", Path: consentsEndpoint, Token: token.Raw, Context: ctx}, &result)
+	if errors.Is(err, NoResultError) {
+		return []Consent{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return result.Data, nil
+}
+
+// GetUserConsents returns the latest decision of the user for every consent.
+func (u *CidaasUtils) GetUserConsents(ctx context.Context, sub string) ([]UserConsent, error) {
+	token, err := u.GetMyAccessToken()
+	if err != nil {
+		return nil, err
+	}
+
+	path := strings.Replace(userConsentsEndpoint, "{sub}", sub, 1)
+
+	var result UserConsentsResponse
+	err = u.doRequest(&RequestInit{Operation: "GetUserConsents", Path: path, Token: token.Raw, Context: ctx}, &result)
+	if errors.Is(err, NoResultError) {
+		return []UserConsent{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return result.Data, nil
+}
+
+// AcceptConsent records that the user accepted the given version of the consent.
+func (u *CidaasUtils) AcceptConsent(ctx context.Context, sub string, consentID string, version string) error {
+	return u.setUserConsent(ctx, "AcceptConsent", sub, consentID, userConsentRequest{Version: version, Status: ConsentAccepted})
+}
+
+// WithdrawConsent records that the user withdrew the consent.
+func (u *CidaasUtils) WithdrawConsent(ctx context.Context, sub string, consentID string) error {
+	return u.setUserConsent(ctx, "WithdrawConsent", sub, consentID, userConsentRequest{Status: ConsentWithdrawn})
+}
+
+func (u *CidaasUtils) setUserConsent(ctx context.Context, operation string, sub string, consentID string, body userConsentRequest) error {
+	path := strings.Replace(userConsentEndpoint, "{sub}", sub, 1)
+	path = strings.Replace(path, "{consent}", url.PathEscape(consentID), 1)
+	defer u.invalidateUserConsents(sub)
+	return u.doAdminRequest(&RequestInit{Operation: operation, Path: path, Method: "PUT", BodyJSON: body, Context: ctx})
+}
+
+// CheckConsents returns ConsentRequiredError if the user did not accept all of the required consents.
+// required maps consent ids to the version which has to be accepted, an empty version accepts any version.
+func (u *CidaasUtils) CheckConsents(ctx context.Context, sub string, required map[string]string) error {
+	missing, err := u.missingConsents(ctx, sub, required)
+	if err != nil {
+		return err
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("%w: %s", ConsentRequiredError, strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// missingConsents returns the sorted ids of all required consents the user did not accept.
+func (u *CidaasUtils) missingConsents(ctx context.Context, sub string, required map[string]string) ([]string, error) {
+	if len(required) == 0 {
+		return nil, nil
+	}
+
+	consents, err := u.cachedUserConsents(ctx, sub)
+	if err != nil {
+		return nil, err
+	}
+
+	accepted := map[string]string{}
+	for _, consent := range consents {
+		if consent.Status == ConsentAccepted {
+			accepted[consent.ConsentID] = consent.Version
+		}
+	}
+
+	var missing []string
+	for consentID, version := range required {
+		acceptedVersion, ok := accepted[consentID]
+		if !ok || (version != "" && acceptedVersion != version) {
+			missing = append(missing, consentID)
+		}
+	}
+	sort.Strings(missing)
+	return missing, nil
+}
+
+// ConsentRequiredResponse is the body the JWTInterceptor sends with 403 if required consents are missing.
+type ConsentRequiredResponse struct {
+	Error    string   `json:"error"`
+	Consents []string `json:"consents"`
+}
+
+func writeConsentRequired(writer http.ResponseWriter, missing []string) {
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusForbidden)
+	json.NewEncoder(writer).Encode(ConsentRequiredResponse{Error: "consent_required", Consents: missing})
+}
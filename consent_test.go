@@ -0,0 +1,135 @@
+package cidaasutils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCidaasUtils_Consents(t *testing.T) {
+	var requests []string
+	utils, server := mockAdminUtils(func(writer http.ResponseWriter, request *http.Request) {
+		body, _ := ioutil.ReadAll(request.Body)
+		requests = append(requests, fmt.Sprintf("%s %s %s", request.Method, request.URL.Path, body))
+
+		switch request.URL.Path {
+		case "/consent-management-srv/consents":
+			writer.Write([]byte(`{"success":true,"data":[{"consentId":"terms","name":"Terms of Service","version":"2"}]}`))
+		case "/consent-management-srv/user/test/consents":
+			writer.Write([]byte(`{"success":true,"data":[{"consentId":"terms","version":"2","status":"ACCEPTED","updatedTime":1614592800000}]}`))
+		default:
+			writer.WriteHeader(204)
+		}
+	})
+	defer server.Close()
+	ctx := context.Background()
+
+	consents, err := utils.ListConsents(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, []Consent{{ID: "terms", Name: "Terms of Service", Version: "2"}}, consents)
+
+	userConsents, err := utils.GetUserConsents(ctx, "test")
+	assert.Nil(t, err)
+	assert.Equal(t, ConsentAccepted, userConsents[0].Status)
+	assert.Equal(t, int64(1614592800), userConsents[0].UpdatedTime.Unix())
+
+	assert.Nil(t, utils.AcceptConsent(ctx, "test", "marketing", "1"))
+	assert.Nil(t, utils.WithdrawConsent(ctx, "test", "newsletter"))
+
+	assert.Nil(t, utils.CheckConsents(ctx, "test", map[string]string{"terms": "2"}))
+	assert.Nil(t, utils.CheckConsents(ctx, "test", map[string]string{"terms": ""}))
+	err = utils.CheckConsents(ctx, "test", map[string]string{"terms": "3", "privacy": ""})
+	assert.True(t, errors.Is(err, ConsentRequiredError))
+	assert.Equal(t, "consent required: privacy, terms", err.Error())
+
+	assert.Equal(t, []string{
+		"GET /consent-management-srv/consents ",
+		"GET /consent-management-srv/user/test/consents ",
+		`PUT /consent-management-srv/user/test/consents/marketing {"version":"1","status":"ACCEPTED"}`,
+		`PUT /consent-management-srv/user/test/consents/newsletter {"status":"WITHDRAWN"}`,
+		"GET /consent-management-srv/user/test/consents ",
+		"GET /consent-management-srv/user/test/consents ",
+		"GET /consent-management-srv/user/test/consents ",
+	}, requests)
+}
+
+func TestCidaasUtils_JWTInterceptor_Consent(t *testing.T) {
+	utils, server := mockAdminUtils(func(writer http.ResponseWriter, request *http.Request) {
+		writer.Write([]byte(`{"success":true,"data":[{"consentId":"terms","version":"1","status":"ACCEPTED"},{"consentId":"marketing","version":"1","status":"WITHDRAWN"}]}`))
+	})
+	defer server.Close()
+	token := signTestToken(jwt.MapClaims{"iss": server.URL, "sub": "test"})
+
+	tests := []struct {
+		options []JWTInterceptorOption
+		status  int
+		body    string
+	}{
+		{[]JWTInterceptorOption{WithConsent("terms", "1")}, 200, ""},
+		{[]JWTInterceptorOption{WithConsent("terms", "")}, 200, ""},
+		{[]JWTInterceptorOption{WithConsent("terms", "2")}, 403, `{"error":"consent_required","consents":["terms"]}` + "\n"},
+		{[]JWTInterceptorOption{WithConsent("terms", "1"), WithConsent("marketing", "")}, 403, `{"error":"consent_required","consents":["marketing"]}` + "\n"},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "", nil)
+		req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
+
+		utils.JWTInterceptor(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			writer.WriteHeader(200)
+		}), test.options...).ServeHTTP(w, req)
+
+		assert.Equal(t, test.status, w.Result().StatusCode)
+		assert.Equal(t, test.body, w.Body.String())
+	}
+}
+
+func TestCidaasUtils_ConsentCache(t *testing.T) {
+	var requests []string
+	accepted := false
+	utils, server := mockAdminUtils(func(writer http.ResponseWriter, request *http.Request) {
+		requests = append(requests, request.Method+" "+request.URL.Path)
+		if request.Method == "GET" && accepted {
+			writer.Write([]byte(`{"success":true,"data":[{"consentId":"terms","version":"1","status":"ACCEPTED"}]}`))
+			return
+		}
+		writer.Write([]byte(`{"success":true,"data":[]}`))
+	})
+	defer server.Close()
+	utils.options.ConsentCache = &ConsentCacheOptions{}
+	ctx := context.Background()
+	required := map[string]string{"terms": "1"}
+
+	assert.True(t, errors.Is(utils.CheckConsents(ctx, "test", required), ConsentRequiredError))
+	assert.True(t, errors.Is(utils.CheckConsents(ctx, "test", required), ConsentRequiredError))
+	assert.Equal(t, []string{"GET /consent-management-srv/user/test/consents"}, requests)
+
+	// accepting a consent invalidates the cached consents of the user
+	accepted = true
+	assert.Nil(t, utils.AcceptConsent(ctx, "test", "terms", "1"))
+	assert.Nil(t, utils.CheckConsents(ctx, "test", required))
+	assert.Nil(t, utils.CheckConsents(ctx, "test", required))
+	assert.Len(t, requests, 3)
+
+	// callers get a copy and can not modify the cached consents
+	consents, err := utils.cachedUserConsents(ctx, "test")
+	assert.Nil(t, err)
+	consents[0].Status = ConsentWithdrawn
+	assert.Nil(t, utils.CheckConsents(ctx, "test", required))
+	assert.Len(t, requests, 3)
+
+	// expired consents are fetched again
+	cache := utils.consentCache()
+	cache.now = func() time.Time { return time.Now().Add(time.Minute) }
+	assert.Nil(t, utils.CheckConsents(ctx, "test", required))
+	assert.Len(t, requests, 4)
+}
@@ -0,0 +1,116 @@
+package cidaasutils
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/mitchellh/mapstructure"
+)
+
+// customFieldTag is the struct tag used to map struct fields to custom fields, e.g. `cidaas:"customer_id"`.
+const customFieldTag = "cidaas"
+
+// CustomFieldsError is returned if custom fields do not match the types of the struct.
+type CustomFieldsError struct {
+	// Errors contains one message per field which could not be converted.
+	Errors []string
+	err    error
+}
+
+func (e *CustomFieldsError) Error() string {
+	return fmt.Sprintf("invalid custom fields: %s", strings.Join(e.Errors, ", "))
+}
+
+func (e *CustomFieldsError) Unwrap() error {
+	return e.err
+}
+
+// DecodeCustomFields decodes the custom fields into out, which has to be a pointer to a struct.
+// Fields are matched by their `cidaas` tag or, if there is none, case insensitive by their name.
+// Timestamps are decoded from RFC 3339 strings into time.Time fields.
+func DecodeCustomFields(fields map[string]CustomField, out interface{}) error {
+	input := make(map[string]interface{}, len(fields))
+	for key, field := range fields {
+		input[key] = field.Value
+	}
+
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		DecodeHook: mapstructure.StringToTimeHookFunc(time.RFC3339),
+		TagName:    customFieldTag,
+		Result:     out,
+	})
+	if err != nil {
+		return err
+	}
+
+	err = decoder.Decode(input)
+	var decodeError *mapstructure.Error
+	if errors.As(err, &decodeError) {
+		return &CustomFieldsError{Errors: decodeError.Errors, err: err}
+	}
+	return err
+}
+
+// EncodeCustomFields encodes a struct or pointer to a struct into custom fields.
+// Fields are named by their `cidaas` tag, fields tagged with "-" are skipped and
+// the "omitempty" option skips zero values, e.g. `cidaas:"customer_id,omitempty"`.
+func EncodeCustomFields(in interface{}) (map[string]CustomField, error) {
+	value := reflect.ValueOf(in)
+	for value.Kind() == reflect.Ptr {
+		if value.IsNil() {
+			return nil, fmt.Errorf("cannot encode custom fields from nil %s", value.Type())
+		}
+		value = value.Elem()
+	}
+	if value.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("cannot encode custom fields from %s, expected a struct", value.Type())
+	}
+
+	result := map[string]CustomField{}
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		if field.PkgPath != "" {
+			// unexported
+			continue
+		}
+
+		name := field.Name
+		omitEmpty := false
+		if tag, ok := field.Tag.Lookup(customFieldTag); ok {
+			parts := strings.Split(tag, ",")
+			if parts[0] == "-" {
+				continue
+			}
+			if parts[0] != "" {
+				name = parts[0]
+			}
+			omitEmpty = includesString(parts[1:], "omitempty")
+		}
+
+		fieldValue := value.Field(i)
+		if omitEmpty && fieldValue.IsZero() {
+			continue
+		}
+		result[name] = CustomField{Value: fieldValue.Interface()}
+	}
+
+	return result, nil
+}
+
+// DecodeCustomFields decodes the custom fields of the user into out. See DecodeCustomFields.
+func (i *UserInfo) DecodeCustomFields(out interface{}) error {
+	return DecodeCustomFields(i.CustomFields, out)
+}
+
+// EncodeCustomFields sets the custom fields of the update request from the struct in. See EncodeCustomFields.
+func (r *UserUpdateRequest) EncodeCustomFields(in interface{}) error {
+	fields, err := EncodeCustomFields(in)
+	if err != nil {
+		return err
+	}
+	r.CustomFields = &fields
+	return nil
+}
@@ -0,0 +1,68 @@
+package cidaasutils
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testCustomFields struct {
+	CustomerID int       `cidaas:"customer_id"`
+	Newsletter bool      `cidaas:"newsletter,omitempty"`
+	Since      time.Time `cidaas:"since"`
+	Tags       []string  `cidaas:"tags,omitempty"`
+	Ignored    string    `cidaas:"-"`
+	Company    string
+}
+
+func TestDecodeCustomFields(t *testing.T) {
+	var info UserInfo
+	err := json.Unmarshal([]byte(`{"customFields":{
+		"customer_id":{"value":15},
+		"newsletter":{"value":true},
+		"since":{"value":"2021-05-01T10:00:00Z"},
+		"tags":{"value":["a","b"]},
+		"company":{"value":"inheaden"}
+	}}`), &info)
+	assert.Nil(t, err)
+
+	var fields testCustomFields
+	assert.Nil(t, info.DecodeCustomFields(&fields))
+	assert.Equal(t, 15, fields.CustomerID)
+	assert.True(t, fields.Newsletter)
+	assert.Equal(t, time.Date(2021, 5, 1, 10, 0, 0, 0, time.UTC), fields.Since)
+	assert.Equal(t, []string{"a", "b"}, fields.Tags)
+	assert.Equal(t, "inheaden", fields.Company)
+}
+
+func TestDecodeCustomFields_TypeMismatch(t *testing.T) {
+	fields := map[string]CustomField{"customer_id": {Value: "abc"}, "newsletter": {Value: "yes"}}
+
+	var result testCustomFields
+	err := DecodeCustomFields(fields, &result)
+
+	var customFieldsError *CustomFieldsError
+	assert.True(t, errors.As(err, &customFieldsError))
+	assert.Len(t, customFieldsError.Errors, 2)
+	assert.Contains(t, err.Error(), "customer_id")
+	assert.Contains(t, err.Error(), "newsletter")
+}
+
+func TestEncodeCustomFields(t *testing.T) {
+	since := time.Date(2021, 5, 1, 10, 0, 0, 0, time.UTC)
+
+	var request UserUpdateRequest
+	err := request.EncodeCustomFields(&testCustomFields{CustomerID: 15, Since: since, Ignored: "x", Company: "inheaden"})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]CustomField{
+		"customer_id": {Value: 15},
+		"since":       {Value: since},
+		"Company":     {Value: "inheaden"},
+	}, *request.CustomFields)
+
+	_, err = EncodeCustomFields("not a struct")
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,125 @@
+package cidaasutils
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// DeviceAccessDeniedError is returned by PollDeviceToken if the user denied the authorization.
+var DeviceAccessDeniedError = errors.New("device authorization was denied")
+
+// DeviceCodeExpiredError is returned by PollDeviceToken if the user did not authorize the device in time.
+var DeviceCodeExpiredError = errors.New("device code is expired")
+
+// devicePollUnit is the unit of interval and expires_in, it is shortened in tests.
+var devicePollUnit = time.Second
+
+// defaultDevicePollInterval is used if Cidaas does not send an interval.
+const defaultDevicePollInterval = 5
+
+// DeviceAuthorization is a started device authorization.
+// Show UserCode and VerificationURI to the user, then call PollDeviceToken.
+type DeviceAuthorization struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete,omitempty"`
+	// ExpiresIn is the lifetime of the device code in seconds.
+	ExpiresIn int `json:"expires_in"`
+	// Interval is the minimum time between two polls in seconds.
+	Interval int `json:"interval,omitempty"`
+
+	startedAt time.Time
+}
+
+// StartDeviceAuthorization starts the OAuth 2.0 device authorization grant (RFC 8628) for the given scopes.
+func (u *CidaasUtils) StartDeviceAuthorization(ctx context.Context, scopes []string) (*DeviceAuthorization, error) {
+	data := url.Values{}
+	data.Add("client_id", u.options.ClientID)
+	if len(scopes) > 0 {
+		data.Add("scope", strings.Join(scopes, " "))
+	}
+
+	var result DeviceAuthorization
+	err := u.doRequest(&RequestInit{Operation: "StartDeviceAuthorization", Path: deviceAuthorizationEndpoint, BodyForm: &data, Method: "POST", Context: ctx}, &result)
+	if err != nil {
+		return nil, err
+	}
+
+	result.startedAt = time.Now()
+	return &result, nil
+}
+
+// PollDeviceToken polls the token endpoint until the user authorized the device.
+// It waits the interval requested by Cidaas between two polls and slows down if asked to.
+// It returns DeviceAccessDeniedError, DeviceCodeExpiredError or the error of ctx if it stops early.
+func (u *CidaasUtils) PollDeviceToken(ctx context.Context, authorization *DeviceAuthorization) (*AccessTokenResult, error) {
+	interval := authorization.Interval
+	if interval <= 0 {
+		interval = defaultDevicePollInterval
+	}
+
+	var expiresAt time.Time
+	if authorization.ExpiresIn > 0 && !authorization.startedAt.IsZero() {
+		expiresAt = authorization.startedAt.Add(time.Duration(authorization.ExpiresIn) * devicePollUnit)
+	}
+
+	data := url.Values{}
+	data.Add("grant_type", GrantTypeDeviceCode)
+	data.Add("client_id", u.options.ClientID)
+	if u.options.ClientSecret != "" {
+		data.Add("client_secret", u.options.ClientSecret)
+	}
+	data.Add("device_code", authorization.DeviceCode)
+
+	for {
+		wait := time.Duration(interval) * devicePollUnit
+		if !expiresAt.IsZero() && time.Now().Add(wait).After(expiresAt) {
+			return nil, DeviceCodeExpiredError
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		}
+
+		var result AccessTokenResult
+		err := u.doRequest(&RequestInit{
+			Operation:           "PollDeviceToken",
+			Path:                tokenEndpoint,
+			BodyForm:            &data,
+			Method:              "POST",
+			Context:             ctx,
+			ExpectedOAuthErrors: []string{"authorization_pending", "slow_down"},
+		}, &result)
+		if cidaasError := AsCidaasError(err); cidaasError != nil {
+			switch cidaasError.OAuthError {
+			case "authorization_pending":
+				continue
+			case "slow_down":
+				interval += 5
+				continue
+			case "access_denied":
+				return nil, DeviceAccessDeniedError
+			case "expired_token":
+				return nil, DeviceCodeExpiredError
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = u.ValidateJWT(result.AccessToken)
+		if err != nil {
+			return nil, err
+		}
+
+		return &result, nil
+	}
+}
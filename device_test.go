@@ -0,0 +1,103 @@
+package cidaasutils
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func mockDeviceUtils(t *testing.T, responses []string) (*CidaasUtils, *httptest.Server, *[]time.Time) {
+	var polls []time.Time
+	var server *httptest.Server
+	utils, server := mockAdminUtils(func(writer http.ResponseWriter, request *http.Request) {
+		switch request.URL.Path {
+		case "/" + deviceAuthorizationEndpoint:
+			assert.Equal(t, "openid profile", request.FormValue("scope"))
+			writer.Write([]byte(`{"device_code":"dc","user_code":"ABCD-EFGH","verification_uri":"https://example.com/device","expires_in":600,"interval":1}`))
+		case "/" + tokenEndpoint:
+			assert.Equal(t, GrantTypeDeviceCode, request.FormValue("grant_type"))
+			assert.Equal(t, "dc", request.FormValue("device_code"))
+			response := responses[len(polls)]
+			polls = append(polls, time.Now())
+			if response == "" {
+				token := signTestToken(jwt.MapClaims{"iss": server.URL, "sub": "test"})
+				writer.Write([]byte(fmt.Sprintf(`{"sub":"test","access_token":"%s","expires_in":3600}`, token)))
+				return
+			}
+			writer.WriteHeader(400)
+			writer.Write([]byte(fmt.Sprintf(`{"error":"%s"}`, response)))
+		}
+	})
+	return utils, server, &polls
+}
+
+func TestCidaasUtils_DeviceAuthorization(t *testing.T) {
+	defer func(unit time.Duration) { devicePollUnit = unit }(devicePollUnit)
+	devicePollUnit = 10 * time.Millisecond
+
+	utils, server, polls := mockDeviceUtils(t, []string{"authorization_pending", "slow_down", "authorization_pending", ""})
+	defer server.Close()
+	ctx := context.Background()
+	var logs bytes.Buffer
+	utils.options.Logger = NewStdLogger(log.New(&logs, "", 0), LogLevelWarn)
+
+	authorization, err := utils.StartDeviceAuthorization(ctx, []string{"openid", "profile"})
+	assert.Nil(t, err)
+	assert.Equal(t, "ABCD-EFGH", authorization.UserCode)
+	assert.Equal(t, "https://example.com/device", authorization.VerificationURI)
+
+	start := time.Now()
+	result, err := utils.PollDeviceToken(ctx, authorization)
+	assert.Nil(t, err)
+	assert.Equal(t, "test", result.Sub)
+	assert.Len(t, *polls, 4)
+
+	// 1 interval, 1 interval, then 6 intervals twice because of slow_down
+	assert.True(t, (*polls)[0].Sub(start) >= 10*time.Millisecond)
+	assert.True(t, (*polls)[2].Sub((*polls)[1]) >= 60*time.Millisecond)
+	assert.True(t, (*polls)[3].Sub((*polls)[2]) >= 60*time.Millisecond)
+
+	// pending authorizations are expected and no warnings
+	assert.Empty(t, logs.String())
+}
+
+func TestCidaasUtils_PollDeviceToken_Errors(t *testing.T) {
+	defer func(unit time.Duration) { devicePollUnit = unit }(devicePollUnit)
+	devicePollUnit = time.Millisecond
+
+	tests := []struct {
+		responses []string
+		err       error
+	}{
+		{[]string{"authorization_pending", "access_denied"}, DeviceAccessDeniedError},
+		{[]string{"expired_token"}, DeviceCodeExpiredError},
+	}
+
+	for _, test := range tests {
+		utils, server, _ := mockDeviceUtils(t, test.responses)
+		_, err := utils.PollDeviceToken(context.Background(), &DeviceAuthorization{DeviceCode: "dc", Interval: 1})
+		assert.True(t, errors.Is(err, test.err), err)
+		server.Close()
+	}
+
+	utils, server, polls := mockDeviceUtils(t, []string{"authorization_pending", "authorization_pending", "authorization_pending"})
+	defer server.Close()
+	authorization := &DeviceAuthorization{DeviceCode: "dc", Interval: 1, ExpiresIn: 2, startedAt: time.Now()}
+	_, err := utils.PollDeviceToken(context.Background(), authorization)
+	assert.True(t, errors.Is(err, DeviceCodeExpiredError))
+	assert.True(t, len(*polls) <= 2)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = utils.PollDeviceToken(ctx, &DeviceAuthorization{DeviceCode: "dc"})
+	assert.True(t, errors.Is(err, context.Canceled))
+}
@@ -0,0 +1,106 @@
+package cidaasutils
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+)
+
+// DiffUserInfo returns the smallest UserUpdateRequest which changes current into desired
+// or nil if there is nothing to change.
+// Custom fields which only exist in current are removed by sending them with a nil value.
+func DiffUserInfo(current, desired *UserInfo) *UserUpdateRequest {
+	result := &UserUpdateRequest{}
+	changed := false
+
+	diffString := func(current string, desired string) *string {
+		if current == desired {
+			return nil
+		}
+		changed = true
+		return &desired
+	}
+
+	result.Email = diffString(current.Identity.Email, desired.Identity.Email)
+	result.FamilyName = diffString(current.Identity.FamilyName, desired.Identity.FamilyName)
+	result.GivenName = diffString(current.Identity.GivenName, desired.Identity.GivenName)
+	result.MobileNumber = diffString(current.Identity.MobileNumber, desired.Identity.MobileNumber)
+	result.Provider = diffString(current.Identity.Provider, desired.Identity.Provider)
+	result.Locale = diffString(current.Identity.Locale, desired.Identity.Locale)
+
+	customFields := map[string]CustomField{}
+	for key, field := range desired.CustomFields {
+		if currentField, ok := current.CustomFields[key]; !ok || !equalJSON(currentField.Value, field.Value) {
+			customFields[key] = field
+		}
+	}
+	for key := range current.CustomFields {
+		if _, ok := desired.CustomFields[key]; !ok {
+			customFields[key] = CustomField{Value: nil}
+		}
+	}
+	if len(customFields) > 0 {
+		result.CustomFields = &customFields
+		changed = true
+	}
+
+	if !changed {
+		return nil
+	}
+	return result
+}
+
+// equalJSON compares both values by their json representation, e.g. the float64 15 equals the int 15.
+func equalJSON(a interface{}, b interface{}) bool {
+	first, err := json.Marshal(a)
+	if err != nil {
+		return false
+	}
+	second, err := json.Marshal(b)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(first, second)
+}
+
+// ApplyUserUpdate fetches the profile of the user, lets mutate change a copy of it and
+// sends the difference to Cidaas. No update is sent if mutate did not change anything.
+// It returns whether the profile was updated.
+func (u *CidaasUtils) ApplyUserUpdate(ctx context.Context, sub string, mutate func(info *UserInfo) error) (bool, error) {
+	current, err := u.fetchUserProfile(ctx, sub)
+	if err != nil {
+		return false, err
+	}
+
+	desired, err := copyUserInfo(current)
+	if err != nil {
+		return false, err
+	}
+	if err := mutate(desired); err != nil {
+		return false, err
+	}
+
+	update := DiffUserInfo(current, desired)
+	if update == nil {
+		return false, nil
+	}
+
+	if err := u.updateUserProfile(ctx, sub, update); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// copyUserInfo returns a deep copy of the user info.
+func copyUserInfo(info *UserInfo) (*UserInfo, error) {
+	data, err := json.Marshal(info)
+	if err != nil {
+		return nil, err
+	}
+
+	var result UserInfo
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
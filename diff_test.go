@@ -0,0 +1,72 @@
+package cidaasutils
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffUserInfo(t *testing.T) {
+	current := &UserInfo{
+		Identity:     UserIdentity{Email: "old@example.com", GivenName: "Jane", Provider: "self"},
+		CustomFields: map[string]CustomField{"customer_id": {Value: 15.0}, "legacy": {Value: "x"}, "plan": {Value: "free"}},
+	}
+	desired := &UserInfo{
+		Identity:     UserIdentity{Email: "new@example.com", GivenName: "Jane", Provider: "self"},
+		CustomFields: map[string]CustomField{"customer_id": {Value: 15}, "plan": {Value: "pro"}, "newsletter": {Value: true}},
+	}
+
+	update := DiffUserInfo(current, desired)
+	assert.NotNil(t, update)
+	assert.Equal(t, "new@example.com", *update.Email)
+	assert.Nil(t, update.GivenName)
+	assert.Nil(t, update.Provider)
+	assert.Equal(t, map[string]CustomField{
+		"plan":       {Value: "pro"},
+		"newsletter": {Value: true},
+		"legacy":     {Value: nil},
+	}, *update.CustomFields)
+
+	data, _ := json.Marshal(update)
+	assert.JSONEq(t, `{"email":"new@example.com","customFields":{"plan":{"value":"pro"},"newsletter":{"value":true},"legacy":{"value":null}}}`, string(data))
+
+	assert.Nil(t, DiffUserInfo(current, current))
+}
+
+func TestCidaasUtils_ApplyUserUpdate(t *testing.T) {
+	var updates []string
+	utils, server := mockAdminUtils(func(writer http.ResponseWriter, request *http.Request) {
+		switch request.Method {
+		case "GET":
+			writer.Write([]byte(`{"success":true,"data":{"identity":{"sub":"sub-1","given_name":"Jane","locale":"de"},"customFields":{"plan":{"value":"free"}}}}`))
+		case "PUT":
+			var body map[string]interface{}
+			json.NewDecoder(request.Body).Decode(&body)
+			data, _ := json.Marshal(body)
+			updates = append(updates, string(data))
+			writer.Write([]byte(`{"success":true,"status":200}`))
+		}
+	})
+	defer server.Close()
+
+	ctx := context.Background()
+	changed, err := utils.ApplyUserUpdate(ctx, "sub-1", func(info *UserInfo) error {
+		info.Identity.Locale = "en"
+		info.CustomFields["plan"] = CustomField{Value: "pro"}
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.True(t, changed)
+
+	changed, err = utils.ApplyUserUpdate(ctx, "sub-1", func(info *UserInfo) error {
+		info.Identity.Locale = "de"
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.False(t, changed)
+
+	assert.Equal(t, []string{`{"customFields":{"plan":{"value":"pro"}},"locale":"en"}`}, updates)
+}
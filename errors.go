@@ -0,0 +1,217 @@
+package cidaasutils
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// requestIDHeaders are the headers which may contain the id Cidaas assigned to a request.
+var requestIDHeaders = []string{"X-Request-Id", "X-Correlation-Id", "X-Amzn-Trace-Id"}
+
+// CidaasError is returned if Cidaas answered a request with a status code >= 300.
+type CidaasError struct {
+	// StatusCode is the HTTP status code of the response.
+	StatusCode int
+	// Method and Path describe the request which failed.
+	Method string
+	Path   string
+	// RequestID is the id Cidaas assigned to the request, if it was sent back.
+	RequestID string
+
+	// OAuthError and OAuthErrorDescription are set for OAuth style errors,
+	// e.g. {"error": "invalid_grant", "error_description": "..."}
+	OAuthError            string
+	OAuthErrorDescription string
+
+	// Envelope is set if Cidaas answered with its {success,status,error} envelope.
+	Envelope *CidaasErrorEnvelope
+
+	// RetryAfter is the parsed Retry-After header, zero if there was none.
+	RetryAfter time.Duration
+
+	// Body contains the raw response body.
+	Body []byte
+}
+
+// CidaasErrorEnvelope is the error envelope used by most Cidaas services.
+type CidaasErrorEnvelope struct {
+	Success bool              `json:"success"`
+	Status  int               `json:"status"`
+	Error   CidaasErrorDetail `json:"error"`
+}
+
+// CidaasErrorDetail describes the error inside of a CidaasErrorEnvelope.
+type CidaasErrorDetail struct {
+	Code            string `json:"code"`
+	Type            string `json:"type"`
+	Message         string `json:"error"`
+	MoreInfo        string `json:"moreInfo"`
+	ReferenceNumber string `json:"referenceNumber"`
+}
+
+// UnmarshalJSON accepts both a plain error string and an error object.
+func (d *CidaasErrorDetail) UnmarshalJSON(data []byte) error {
+	var message string
+	if err := json.Unmarshal(data, &message); err == nil {
+		d.Message = message
+		return nil
+	}
+
+	var detail struct {
+		Code            interface{} `json:"code"`
+		Type            string      `json:"type"`
+		Message         string      `json:"error"`
+		MoreInfo        string      `json:"moreInfo"`
+		ReferenceNumber string      `json:"referenceNumber"`
+	}
+	if err := json.Unmarshal(data, &detail); err != nil {
+		return err
+	}
+
+	if detail.Code != nil {
+		d.Code = fmt.Sprint(detail.Code)
+	}
+	d.Type = detail.Type
+	d.Message = detail.Message
+	d.MoreInfo = detail.MoreInfo
+	d.ReferenceNumber = detail.ReferenceNumber
+	return nil
+}
+
+func (e *CidaasError) Error() string {
+	message := fmt.Sprintf("Cidaas Error: request to %s was not successful, status code was %d", e.Path, e.StatusCode)
+	if description := e.Description(); description != "" {
+		message = fmt.Sprintf("%s: %s", message, description)
+	}
+	return message
+}
+
+// Code returns the machine readable error code, either the OAuth error or the code of the envelope.
+func (e *CidaasError) Code() string {
+	if e.OAuthError != "" {
+		return e.OAuthError
+	}
+	if e.Envelope != nil {
+		return e.Envelope.Error.Code
+	}
+	return ""
+}
+
+// Description returns the human readable error description if Cidaas sent one.
+func (e *CidaasError) Description() string {
+	if e.OAuthErrorDescription != "" {
+		return e.OAuthErrorDescription
+	}
+	if e.Envelope != nil && e.Envelope.Error.Message != "" {
+		return e.Envelope.Error.Message
+	}
+	return e.OAuthError
+}
+
+// newCidaasError creates a CidaasError from the given response and its already read body.
+func newCidaasError(resp *http.Response, body []byte) *CidaasError {
+	result := &CidaasError{
+		StatusCode: resp.StatusCode,
+		Body:       body,
+		RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+	}
+
+	if resp.Request != nil {
+		result.Method = resp.Request.Method
+		result.Path = resp.Request.URL.Path
+	}
+
+	for _, header := range requestIDHeaders {
+		if value := resp.Header.Get(header); value != "" {
+			result.RequestID = value
+			break
+		}
+	}
+
+	var parsed struct {
+		Success          *bool           `json:"success"`
+		Status           int             `json:"status"`
+		Error            json.RawMessage `json:"error"`
+		ErrorDescription string          `json:"error_description"`
+	}
+	if err := json.Unmarshal(body, &parsed); err != nil {
+		return result
+	}
+
+	if parsed.Success != nil {
+		envelope := &CidaasErrorEnvelope{Success: *parsed.Success, Status: parsed.Status}
+		if len(parsed.Error) > 0 {
+			_ = json.Unmarshal(parsed.Error, &envelope.Error)
+		}
+		result.Envelope = envelope
+		return result
+	}
+
+	var oauthError string
+	if err := json.Unmarshal(parsed.Error, &oauthError); err == nil {
+		result.OAuthError = oauthError
+		result.OAuthErrorDescription = parsed.ErrorDescription
+	}
+
+	return result
+}
+
+// parseRetryAfter parses a Retry-After header given either in seconds or as a http date.
+func parseRetryAfter(value string) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+
+	if date, err := http.ParseTime(value); err == nil {
+		if wait := time.Until(date); wait > 0 {
+			return wait
+		}
+	}
+	return 0
+}
+
+// AsCidaasError returns the CidaasError contained in err or nil.
+func AsCidaasError(err error) *CidaasError {
+	var cidaasError *CidaasError
+	if errors.As(err, &cidaasError) {
+		return cidaasError
+	}
+	return nil
+}
+
+func hasStatus(err error, status int) bool {
+	cidaasError := AsCidaasError(err)
+	return cidaasError != nil && cidaasError.StatusCode == status
+}
+
+// IsNotFound reports whether Cidaas answered with 404.
+func IsNotFound(err error) bool {
+	return hasStatus(err, http.StatusNotFound)
+}
+
+// IsUnauthorized reports whether Cidaas answered with 401.
+func IsUnauthorized(err error) bool {
+	return hasStatus(err, http.StatusUnauthorized)
+}
+
+// IsRateLimited reports whether Cidaas answered with 429.
+func IsRateLimited(err error) bool {
+	return hasStatus(err, http.StatusTooManyRequests)
+}
+
+// IsInvalidGrant reports whether the token endpoint rejected the given grant,
+// e.g. because a refresh token or authorization code is expired or was already used.
+func IsInvalidGrant(err error) bool {
+	cidaasError := AsCidaasError(err)
+	return cidaasError != nil && cidaasError.OAuthError == "invalid_grant"
+}
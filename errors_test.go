@@ -0,0 +1,79 @@
+package cidaasutils
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func mockServerUtils(handler http.HandlerFunc) (*CidaasUtils, *httptest.Server) {
+	server := httptest.NewServer(handler)
+	utils := New(&Options{BaseURL: server.URL})
+	return utils, server
+}
+
+func TestCidaasError_OAuth(t *testing.T) {
+	utils, server := mockServerUtils(func(writer http.ResponseWriter, request *http.Request) {
+		writer.Header().Set("X-Request-Id", "request-1")
+		writer.WriteHeader(http.StatusBadRequest)
+		writer.Write([]byte(`{"error":"invalid_grant","error_description":"refresh token expired"}`))
+	})
+	defer server.Close()
+
+	_, err := utils.RefreshTokenFlow("expired")
+	assert.NotNil(t, err)
+	assert.True(t, IsInvalidGrant(err))
+	assert.False(t, IsNotFound(err))
+
+	cidaasError := AsCidaasError(err)
+	assert.NotNil(t, cidaasError)
+	assert.Equal(t, 400, cidaasError.StatusCode)
+	assert.Equal(t, "/"+tokenEndpoint, cidaasError.Path)
+	assert.Equal(t, "POST", cidaasError.Method)
+	assert.Equal(t, "request-1", cidaasError.RequestID)
+	assert.Equal(t, "invalid_grant", cidaasError.Code())
+	assert.Equal(t, "refresh token expired", cidaasError.Description())
+	assert.Nil(t, cidaasError.Envelope)
+}
+
+func TestCidaasError_Envelope(t *testing.T) {
+	utils, server := mockServerUtils(func(writer http.ResponseWriter, request *http.Request) {
+		writer.WriteHeader(http.StatusNotFound)
+		writer.Write([]byte(`{"success":false,"status":404,"error":{"code":10001,"type":"UserException","error":"user not found"}}`))
+	})
+	defer server.Close()
+
+	var result SimpleStatusResponse
+	err := utils.doRequest(&RequestInit{Path: "users-srv/user/test", Method: "GET"}, &result)
+	assert.True(t, IsNotFound(err))
+
+	cidaasError := AsCidaasError(err)
+	assert.NotNil(t, cidaasError.Envelope)
+	assert.False(t, cidaasError.Envelope.Success)
+	assert.Equal(t, 404, cidaasError.Envelope.Status)
+	assert.Equal(t, "10001", cidaasError.Code())
+	assert.Equal(t, "UserException", cidaasError.Envelope.Error.Type)
+	assert.Equal(t, "user not found", cidaasError.Description())
+}
+
+func TestCidaasError_RateLimited(t *testing.T) {
+	utils, server := mockServerUtils(func(writer http.ResponseWriter, request *http.Request) {
+		writer.Header().Set("Retry-After", "3")
+		writer.WriteHeader(http.StatusTooManyRequests)
+		writer.Write([]byte(`too many requests`))
+	})
+	defer server.Close()
+
+	var result SimpleStatusResponse
+	err := utils.doRequest(&RequestInit{Path: "users-srv/user/test", Method: "GET"}, &result)
+	assert.True(t, IsRateLimited(err))
+	assert.False(t, IsUnauthorized(err))
+
+	cidaasError := AsCidaasError(err)
+	assert.Equal(t, 3*time.Second, cidaasError.RetryAfter)
+	assert.Equal(t, []byte(`too many requests`), cidaasError.Body)
+	assert.Equal(t, "", cidaasError.Code())
+}
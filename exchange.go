@@ -0,0 +1,66 @@
+package cidaasutils
+
+import (
+	"context"
+	"net/url"
+	"strings"
+)
+
+// Token types used by the token exchange.
+const (
+	TokenTypeAccessToken = "urn:ietf:params:oauth:token-type:access_token"
+	TokenTypeJWT         = "urn:ietf:params:oauth:token-type:jwt"
+)
+
+// TokenActor is the party which acts on behalf of the subject of a token, see the act claim of RFC 8693.
+// Actor is set if the actor itself acts on behalf of another party.
+type TokenActor struct {
+	Sub      string      `json:"sub,omitempty" mapstructure:"sub"`
+	ClientID string      `json:"client_id,omitempty" mapstructure:"client_id"`
+	Actor    *TokenActor `json:"act,omitempty" mapstructure:"act"`
+}
+
+// Chain returns the subjects of all actors, starting with the current actor.
+func (a *TokenActor) Chain() []string {
+	var result []string
+	for actor := a; actor != nil; actor = actor.Actor {
+		result = append(result, actor.Sub)
+	}
+	return result
+}
+
+// ExchangeToken exchanges the subject token for a token for the given audience (RFC 8693),
+// e.g. to call a downstream service on behalf of a user with a narrower token.
+// If actorToken is given, the new token contains the actor in its act claim.
+func (u *CidaasUtils) ExchangeToken(ctx context.Context, subjectToken string, audience string, scopes []string, actorToken string) (*AccessTokenResult, error) {
+	data := url.Values{}
+	data.Add("grant_type", GrantTypeTokenExchange)
+	data.Add("client_id", u.options.ClientID)
+	data.Add("client_secret", u.options.ClientSecret)
+	data.Add("subject_token", subjectToken)
+	data.Add("subject_token_type", TokenTypeAccessToken)
+	data.Add("requested_token_type", TokenTypeAccessToken)
+	if audience != "" {
+		data.Add("audience", audience)
+	}
+	if len(scopes) > 0 {
+		data.Add("scope", strings.Join(scopes, " "))
+	}
+	if actorToken != "" {
+		data.Add("actor_token", actorToken)
+		data.Add("actor_token_type", TokenTypeAccessToken)
+	}
+
+	var result AccessTokenResult
+	err := u.doRequest(&RequestInit{Operation: "ExchangeToken", Path: tokenEndpoint, BodyForm: &data, Method: "POST", Context: ctx}, &result)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = u.ValidateJWT(result.AccessToken)
+	if err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
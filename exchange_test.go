@@ -0,0 +1,73 @@
+package cidaasutils
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCidaasUtils_ExchangeToken(t *testing.T) {
+	var server *httptest.Server
+	utils, server := mockAdminUtils(func(writer http.ResponseWriter, request *http.Request) {
+		assert.Equal(t, GrantTypeTokenExchange, request.FormValue("grant_type"))
+		assert.Equal(t, "user-token", request.FormValue("subject_token"))
+		assert.Equal(t, TokenTypeAccessToken, request.FormValue("subject_token_type"))
+		assert.Equal(t, "orders", request.FormValue("audience"))
+		assert.Equal(t, "orders:read", request.FormValue("scope"))
+		assert.Equal(t, "gateway-token", request.FormValue("actor_token"))
+		assert.Equal(t, TokenTypeAccessToken, request.FormValue("actor_token_type"))
+
+		token := signTestToken(jwt.MapClaims{
+			"iss": server.URL,
+			"sub": "user",
+			"act": map[string]interface{}{"sub": "gateway", "client_id": "gateway-client"},
+		})
+		writer.Write([]byte(fmt.Sprintf(`{"access_token":"%s","issued_token_type":"%s","expires_in":300}`, token, TokenTypeAccessToken)))
+	})
+	defer server.Close()
+
+	result, err := utils.ExchangeToken(context.Background(), "user-token", "orders", []string{"orders:read"}, "gateway-token")
+	assert.Nil(t, err)
+	assert.Equal(t, TokenTypeAccessToken, result.IssuedTokenType)
+
+	token, err := utils.ValidateJWT(result.AccessToken)
+	assert.Nil(t, err)
+	claims, err := utils.ToCidaasTokenClaims(token)
+	assert.Nil(t, err)
+	assert.Equal(t, "user", claims.Sub)
+	assert.Equal(t, &TokenActor{Sub: "gateway", ClientID: "gateway-client"}, claims.Actor)
+}
+
+func TestCidaasUtils_ExchangeToken_InvalidGrant(t *testing.T) {
+	utils, server := mockAdminUtils(func(writer http.ResponseWriter, request *http.Request) {
+		assert.Equal(t, "", request.FormValue("actor_token"))
+		writer.WriteHeader(400)
+		writer.Write([]byte(`{"error":"invalid_grant","error_description":"subject token is expired"}`))
+	})
+	defer server.Close()
+
+	_, err := utils.ExchangeToken(context.Background(), "user-token", "orders", nil, "")
+	assert.True(t, IsInvalidGrant(err))
+}
+
+func TestToCidaasTokenClaims_ActorChain(t *testing.T) {
+	claims, err := toCidaasTokenClaims(&jwt.MapClaims{
+		"sub": "user",
+		"act": map[string]interface{}{
+			"sub": "service-b",
+			"act": map[string]interface{}{"sub": "gateway"},
+		},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"service-b", "gateway"}, claims.Actor.Chain())
+
+	claims, err = toCidaasTokenClaims(&jwt.MapClaims{"sub": "user"})
+	assert.Nil(t, err)
+	assert.Nil(t, claims.Actor)
+	assert.Nil(t, claims.Actor.Chain())
+}
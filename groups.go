@@ -0,0 +1,145 @@
+package cidaasutils
+
+import (
+	"context"
+	"errors"
+	"strings"
+)
+
+// Group is a user group of the tenant.
+type Group struct {
+	GroupID      string                 `json:"groupId"`
+	GroupType    string                 `json:"groupType,omitempty"`
+	GroupName    string                 `json:"groupName,omitempty"`
+	ParentID     string                 `json:"parentId,omitempty"`
+	Description  string                 `json:"description,omitempty"`
+	Roles        []string               `json:"roles,omitempty"`
+	CustomFields map[string]CustomField `json:"customFields,omitempty"`
+}
+
+// GroupMembership describes the membership of a user in a group and the group roles of the user.
+type GroupMembership struct {
+	GroupID string   `json:"groupId" mapstructure:"groupId"`
+	Roles   []string `json:"roles" mapstructure:"roles"`
+}
+
+type GroupResponse struct {
+	Success bool  `json:"success"`
+	Status  int   `json:"status"`
+	Data    Group `json:"data"`
+}
+
+type GroupsResponse struct {
+	Success bool    `json:"success"`
+	Status  int     `json:"status"`
+	Data    []Group `json:"data"`
+}
+
+type GroupMembershipsResponse struct {
+	Success bool              `json:"success"`
+	Status  int               `json:"status"`
+	Data    []GroupMembership `json:"data"`
+}
+
+type groupMemberRequest struct {
+	Sub   string   `json:"sub"`
+	Roles []string `json:"roles"`
+}
+
+// ListGroups returns all groups of the tenant.
+func (u *CidaasUtils) ListGroups(ctx context.Context) ([]Group, error) {
+	token, err := u.GetMyAccessToken()
+	if err != nil {
+		return nil, err
+	}
+
+	var result GroupsResponse
+	err = u.doRequest(&RequestInit{Operation: "ListGroups", Path: groupsEndpoint, Token: token.Raw, Context: ctx}, &result)
+	if errors.Is(err, NoResultError) {
+		return []Group{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return result.Data, nil
+}
+
+// GetGroup returns the group with the given id.
+func (u *CidaasUtils) GetGroup(ctx context.Context, groupID string) (*Group, error) {
+	token, err := u.GetMyAccessToken()
+	if err != nil {
+		return nil, err
+	}
+
+	path := strings.Replace(groupEndpoint, "{group}", groupID, 1)
+
+	var result GroupResponse
+	err = u.doRequest(&RequestInit{Operation: "GetGroup", Path: path, Token: token.Raw, Context: ctx}, &result)
+	if err != nil {
+		return nil, err
+	}
+
+	return &result.Data, nil
+}
+
+// CreateGroup creates a new group.
+func (u *CidaasUtils) CreateGroup(ctx context.Context, group *Group) error {
+	return u.doAdminRequest(&RequestInit{Operation: "CreateGroup", Path: groupsEndpoint, Method: "POST", BodyJSON: group, Context: ctx})
+}
+
+// UpdateGroup updates the group with the id group.GroupID.
+func (u *CidaasUtils) UpdateGroup(ctx context.Context, group *Group) error {
+	path := strings.Replace(groupEndpoint, "{group}", group.GroupID, 1)
+	return u.doAdminRequest(&RequestInit{Operation: "UpdateGroup", Path: path, Method: "PUT", BodyJSON: group, Context: ctx})
+}
+
+// DeleteGroup deletes the group with the given id.
+func (u *CidaasUtils) DeleteGroup(ctx context.Context, groupID string) error {
+	path := strings.Replace(groupEndpoint, "{group}", groupID, 1)
+	return u.doAdminRequest(&RequestInit{Operation: "DeleteGroup", Path: path, Method: "DELETE", Context: ctx})
+}
+
+// AddUserToGroup adds the user to the group with the given group roles.
+// If the user is already a member, the group roles are replaced.
+func (u *CidaasUtils) AddUserToGroup(ctx context.Context, groupID string, sub string, roles []string) error {
+	path := strings.Replace(groupMembersEndpoint, "{group}", groupID, 1)
+	body := groupMemberRequest{Sub: sub, Roles: roles}
+	return u.doAdminRequest(&RequestInit{Operation: "AddUserToGroup", Path: path, Method: "POST", BodyJSON: body, Context: ctx})
+}
+
+// RemoveUserFromGroup removes the user from the group.
+func (u *CidaasUtils) RemoveUserFromGroup(ctx context.Context, groupID string, sub string) error {
+	path := strings.Replace(groupMemberEndpoint, "{group}", groupID, 1)
+	path = strings.Replace(path, "{sub}", sub, 1)
+	return u.doAdminRequest(&RequestInit{Operation: "RemoveUserFromGroup", Path: path, Method: "DELETE", Context: ctx})
+}
+
+// GetUserGroups returns all group memberships of the user.
+func (u *CidaasUtils) GetUserGroups(ctx context.Context, sub string) ([]GroupMembership, error) {
+	token, err := u.GetMyAccessToken()
+	if err != nil {
+		return nil, err
+	}
+
+	path := strings.Replace(userGroupsEndpoint, "{sub}", sub, 1)
+
+	var result GroupMembershipsResponse
+	err = u.doRequest(&RequestInit{Operation: "GetUserGroups", Path: path, Token: token.Raw, Context: ctx}, &result)
+	if errors.Is(err, NoResultError) {
+		return []GroupMembership{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return result.Data, nil
+}
+
+// hasGroupRoles reports whether the memberships contain the group with all of the given roles.
+func hasGroupRoles(memberships []GroupMembership, groupID string, roles []string) bool {
+	for _, membership := range memberships {
+		if membership.GroupID == groupID {
+			return includesStrings(membership.Roles, roles)
+		}
+	}
+	return false
+}
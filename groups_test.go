@@ -0,0 +1,95 @@
+package cidaasutils
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCidaasUtils_Groups(t *testing.T) {
+	var requests []string
+	utils, server := mockAdminUtils(func(writer http.ResponseWriter, request *http.Request) {
+		requests = append(requests, request.Method+" "+request.URL.Path)
+		switch request.Method + " " + request.URL.Path {
+		case "GET /groups-srv/groups/g1":
+			writer.Write([]byte(`{"success":true,"status":200,"data":{"groupId":"g1","groupName":"Group 1","roles":["member","admin"]}}`))
+		case "POST /groups-srv/groups/g1/users":
+			var body groupMemberRequest
+			json.NewDecoder(request.Body).Decode(&body)
+			assert.Equal(t, groupMemberRequest{Sub: "sub-1", Roles: []string{"admin"}}, body)
+			writer.Write([]byte(`{"success":true,"status":200}`))
+		case "GET /groups-srv/users/sub-1/groups":
+			writer.Write([]byte(`{"success":true,"status":200,"data":[{"groupId":"g1","roles":["admin"]}]}`))
+		default:
+			writer.Write([]byte(`{"success":true,"status":200}`))
+		}
+	})
+	defer server.Close()
+
+	ctx := context.Background()
+	assert.Nil(t, utils.CreateGroup(ctx, &Group{GroupID: "g1", GroupName: "Group 1"}))
+
+	group, err := utils.GetGroup(ctx, "g1")
+	assert.Nil(t, err)
+	assert.Equal(t, "Group 1", group.GroupName)
+	assert.Equal(t, []string{"member", "admin"}, group.Roles)
+
+	assert.Nil(t, utils.UpdateGroup(ctx, group))
+	assert.Nil(t, utils.AddUserToGroup(ctx, "g1", "sub-1", []string{"admin"}))
+
+	memberships, err := utils.GetUserGroups(ctx, "sub-1")
+	assert.Nil(t, err)
+	assert.Equal(t, []GroupMembership{{GroupID: "g1", Roles: []string{"admin"}}}, memberships)
+
+	assert.Nil(t, utils.RemoveUserFromGroup(ctx, "g1", "sub-1"))
+	assert.Nil(t, utils.DeleteGroup(ctx, "g1"))
+
+	assert.Equal(t, []string{
+		"POST /groups-srv/groups",
+		"GET /groups-srv/groups/g1",
+		"PUT /groups-srv/groups/g1",
+		"POST /groups-srv/groups/g1/users",
+		"GET /groups-srv/users/sub-1/groups",
+		"DELETE /groups-srv/groups/g1/users/sub-1",
+		"DELETE /groups-srv/groups/g1",
+	}, requests)
+}
+
+func TestCidaasUtils_JWTInterceptor_Groups(t *testing.T) {
+	utils := mockUtils()
+	token := signTestToken(jwt.MapClaims{
+		"iss":    "https://example.com",
+		"sub":    "test",
+		"groups": []map[string]interface{}{{"groupId": "g1", "roles": []string{"member", "admin"}}},
+	})
+
+	tests := []struct {
+		option JWTInterceptorOption
+		status int
+	}{
+		{WithGroup("g1"), 200},
+		{WithGroupRoles("g1", []string{"admin"}), 200},
+		{WithGroupRoles("g1", []string{"owner"}), 403},
+		{WithGroup("g2"), 403},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "", nil)
+		req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
+
+		utils.JWTInterceptor(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			claims := GetAuthContext(request.Context())
+			assert.Equal(t, "g1", claims.Groups[0].GroupID)
+			writer.WriteHeader(200)
+		}), test.option).ServeHTTP(w, req)
+
+		assert.Equal(t, test.status, w.Result().StatusCode)
+	}
+}
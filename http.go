@@ -8,7 +8,6 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
-	"log"
 	"net/http"
 	"net/url"
 	"strconv"
@@ -20,17 +19,76 @@ import (
 var jwkEndpoint = ".well-known/jwks.json"
 var userinfoInternalEndpoint = "users-srv/internal/userinfo/profile/{sub}"
 var userUpdateEndpoint = "users-srv/user/{sub}"
+var userCreateEndpoint = "users-srv/user"
+var userStatusEndpoint = "users-srv/user/{sub}/status"
+var userLockEndpoint = "users-srv/user/{sub}/lock"
+var userSearchEndpoint = "users-srv/user/search"
+var userIdentitiesEndpoint = "users-srv/user/{sub}/identities"
+var userIdentityEndpoint = "users-srv/user/{sub}/identities/{identity}"
+var userPrimaryIdentityEndpoint = "users-srv/user/{sub}/identities/{identity}/primary"
+var linkIdentityEndpoint = "users-srv/user/linkaccount"
+var userPasswordEndpoint = "users-srv/user/{sub}/password"
+var changePasswordEndpoint = "users-srv/changepassword"
+var resetPasswordInitiateEndpoint = "users-srv/resetpassword/initiate"
+var resetPasswordValidateEndpoint = "users-srv/resetpassword/validatecode"
+var resetPasswordAcceptEndpoint = "users-srv/resetpassword/accept"
+var userSessionsEndpoint = "session-srv/user/{sub}/sessions"
+var userSessionsRevokeEndpoint = "session-srv/user/{sub}/sessions/revoke"
+var verificationInitiateEndpoint = "verification-srv/account/initiate"
+var verificationVerifyEndpoint = "verification-srv/account/verify"
+var userRolesEndpoint = "users-srv/user/{sub}/roles"
+var userRoleEndpoint = "users-srv/user/{sub}/roles/{role}"
+var rolesEndpoint = "roles-srv/roles"
+var roleEndpoint = "roles-srv/roles/{role}"
+var groupsEndpoint = "groups-srv/groups"
+var groupEndpoint = "groups-srv/groups/{group}"
+var groupMembersEndpoint = "groups-srv/groups/{group}/users"
+var groupMemberEndpoint = "groups-srv/groups/{group}/users/{sub}"
+var userGroupsEndpoint = "groups-srv/users/{sub}/groups"
+var consentsEndpoint = "consent-management-srv/consents"
+var userConsentsEndpoint = "consent-management-srv/user/{sub}/consents"
+var userConsentEndpoint = "consent-management-srv/user/{sub}/consents/{consent}"
+var appsEndpoint = "apps-srv/clients"
+var appEndpoint = "apps-srv/clients/{client}"
+var appSecretEndpoint = "apps-srv/clients/{client}/secret"
+var deviceAuthorizationEndpoint = "authz-srv/device/authz"
 var tokenEndpoint = "token-srv/token"
 
 var NoResultError = errors.New("no results")
 
+// requestTimeout is the maximum time a single request to Cidaas may take.
+var requestTimeout = time.Second * 30
+
 type RequestInit struct {
-	Path     string
-	Token    string
-	Method   string
-	BodyForm *url.Values
-	BodyJSON interface{}
-	Context  context.Context
+	// Operation is the logical name of the call, e.g. "GetUserProfileInternally".
+	Operation string
+	Path      string
+	Token     string
+	Method    string
+	BodyForm  *url.Values
+	BodyJSON  interface{}
+	Context   context.Context
+	// ExpectedOAuthErrors are OAuth error codes which are a normal answer to the request,
+	// e.g. while polling. They are logged at debug instead of warn level.
+	ExpectedOAuthErrors []string
+}
+
+// cidaasTransport is the http.RoundTripper used for all requests to Cidaas.
+// It applies the rate limiter and the circuit breaker of the endpoint group.
+type cidaasTransport struct {
+	utils *CidaasUtils
+	next  http.RoundTripper
+}
+
+func (t *cidaasTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	return t.utils.applyRateLimit(request, func() (*http.Response, error) {
+		return t.utils.applyCircuitBreaker(request, t.next)
+	})
+}
+
+// httpClient returns the client used for all requests to Cidaas.
+func (u *CidaasUtils) httpClient() *http.Client {
+	return &http.Client{Transport: u.applyMiddlewares(&cidaasTransport{utils: u, next: http.DefaultTransport})}
 }
 
 // buildURL builds a url to talk with cidaas
@@ -38,14 +96,7 @@ func (u *CidaasUtils) buildUrl(path string) string {
 	return fmt.Sprintf("%s/%s", u.options.BaseURL, path)
 }
 
-func (u *CidaasUtils) buildRequest(init *RequestInit) (*http.Request, error) {
-	var ctx context.Context
-	if init.Context != nil {
-		ctx, _ = context.WithTimeout(init.Context, time.Second*30)
-	} else {
-		ctx, _ = context.WithTimeout(context.Background(), time.Second*30)
-	}
-
+func (u *CidaasUtils) buildRequest(ctx context.Context, init *RequestInit) (*http.Request, error) {
 	var body io.Reader
 	if init.BodyForm != nil {
 		body = strings.NewReader(init.BodyForm.Encode())
@@ -77,36 +128,75 @@ func (u *CidaasUtils) buildRequest(init *RequestInit) (*http.Request, error) {
 }
 
 func (u *CidaasUtils) doRequest(init *RequestInit, result interface{}) error {
-	res, err := u.buildRequest(init)
-	resp, err := doRequest(res)
+	ctx := init.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	ctx, cancel := context.WithTimeout(withRequestInit(ctx, init), requestTimeout)
+	defer cancel()
+
+	req, err := u.buildRequest(ctx, init)
 	if err != nil {
 		return err
 	}
 
+	if init.BodyForm != nil {
+		u.logger().Debug("cidaas request body", "path", init.Path, "body", redactValues(*init.BodyForm).Encode())
+	} else if init.BodyJSON != nil {
+		requestBody, _ := json.Marshal(init.BodyJSON)
+		u.logger().Debug("cidaas request body", "path", init.Path, "body", redactBody(requestBody))
+	}
+
+	resp, err := u.send(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
 	if resp.StatusCode == 204 {
 		return NoResultError
 	}
 
-	defer resp.Body.Close()
 	body, err := ioutil.ReadAll(resp.Body)
-	err = json.Unmarshal(body, result)
-	return err
+	if err != nil {
+		return err
+	}
+	if len(body) == 0 {
+		return nil
+	}
+	return json.Unmarshal(body, result)
 }
 
-func doRequest(request *http.Request) (*http.Response, error) {
-	log.Print(request.URL)
-	client := &http.Client{}
-	resp, err := client.Do(request)
+// send executes the request and converts unsuccessful responses into a CidaasError.
+func (u *CidaasUtils) send(request *http.Request) (*http.Response, error) {
+	logger := u.logger()
+	logger.Debug("cidaas request", "method", request.Method, "url", redactURL(request.URL))
+
+	resp, err := u.httpClient().Do(request)
 	if err != nil {
+		logger.Error("cidaas request failed", "method", request.Method, "url", redactURL(request.URL), "error", err)
 		return nil, err
 	}
 
 	if resp.StatusCode >= 300 {
 		defer resp.Body.Close()
 		b, _ := ioutil.ReadAll(resp.Body)
-		log.Printf("Cidaas Error: error body: %s", string(b))
-		return nil, errors.New(fmt.Sprintf("Cidaas Error: request to %s was not successful, status code was %d", request.URL, resp.StatusCode))
+		cidaasError := newCidaasError(resp, b)
+		log := logger.Warn
+		if init := RequestInitFromContext(request.Context()); init != nil && cidaasError.OAuthError != "" &&
+			includesString(init.ExpectedOAuthErrors, cidaasError.OAuthError) {
+			log = logger.Debug
+		}
+		log("cidaas request was not successful",
+			"method", request.Method,
+			"url", redactURL(request.URL),
+			"status", resp.StatusCode,
+			"request_id", cidaasError.RequestID,
+			"body", redactBody(b),
+		)
+		return nil, cidaasError
 	}
 
+	logger.Debug("cidaas response", "method", request.Method, "url", redactURL(request.URL), "status", resp.StatusCode)
 	return resp, err
 }
@@ -0,0 +1,102 @@
+package cidaasutils
+
+import (
+	"context"
+	"errors"
+	"strings"
+)
+
+// UserStatus is the account status of a user.
+type UserStatus string
+
+const (
+	UserStatusActive   UserStatus = "ACTIVE"
+	UserStatusInactive UserStatus = "INACTIVE"
+	UserStatusLocked   UserStatus = "LOCKED"
+	UserStatusDeleted  UserStatus = "DELETED"
+)
+
+type UserStatusRequest struct {
+	Status UserStatus `json:"status"`
+}
+
+type UserStatusResponse struct {
+	Success bool `json:"success"`
+	Status  int  `json:"status"`
+	Data    struct {
+		UserStatus UserStatus `json:"userStatus"`
+	} `json:"data"`
+}
+
+// DeleteUser deletes the user with the given sub.
+func (u *CidaasUtils) DeleteUser(ctx context.Context, sub string) (UserStatus, error) {
+	path := strings.Replace(userUpdateEndpoint, "{sub}", sub, 1)
+	status, err := u.sendUserStatusChange(ctx, sub, "DeleteUser", &RequestInit{Path: path, Method: "DELETE"})
+	if err == nil && status == "" {
+		// the request succeeded and there is no profile left to read the status from
+		status = UserStatusDeleted
+	}
+	return status, err
+}
+
+// DeactivateUser deactivates the user, who will not be able to log in anymore.
+func (u *CidaasUtils) DeactivateUser(ctx context.Context, sub string) (UserStatus, error) {
+	path := strings.Replace(userStatusEndpoint, "{sub}", sub, 1)
+	return u.changeUserStatus(ctx, sub, "DeactivateUser", &RequestInit{Path: path, Method: "PUT", BodyJSON: UserStatusRequest{Status: UserStatusInactive}})
+}
+
+// ActivateUser activates a previously deactivated user.
+func (u *CidaasUtils) ActivateUser(ctx context.Context, sub string) (UserStatus, error) {
+	path := strings.Replace(userStatusEndpoint, "{sub}", sub, 1)
+	return u.changeUserStatus(ctx, sub, "ActivateUser", &RequestInit{Path: path, Method: "PUT", BodyJSON: UserStatusRequest{Status: UserStatusActive}})
+}
+
+// LockUser locks the user account, e.g. after a security incident.
+func (u *CidaasUtils) LockUser(ctx context.Context, sub string) (UserStatus, error) {
+	path := strings.Replace(userLockEndpoint, "{sub}", sub, 1)
+	return u.changeUserStatus(ctx, sub, "LockUser", &RequestInit{Path: path, Method: "POST"})
+}
+
+// UnlockUser unlocks a locked user account.
+func (u *CidaasUtils) UnlockUser(ctx context.Context, sub string) (UserStatus, error) {
+	path := strings.Replace(userLockEndpoint, "{sub}", sub, 1)
+	return u.changeUserStatus(ctx, sub, "UnlockUser", &RequestInit{Path: path, Method: "DELETE"})
+}
+
+// changeUserStatus sends the request with the admin token and returns the new status of the user.
+// If Cidaas does not return the status, it is read from the profile of the user.
+// The status is empty if it could not be read.
+func (u *CidaasUtils) changeUserStatus(ctx context.Context, sub string, operation string, init *RequestInit) (UserStatus, error) {
+	status, err := u.sendUserStatusChange(ctx, sub, operation, init)
+	if err != nil || status != "" {
+		return status, err
+	}
+
+	info, err := u.fetchUserProfile(ctx, sub)
+	if err != nil {
+		// the status was changed, only reading it back failed
+		u.logger().Warn("could not read user status", "sub", sub, "error", err)
+		return "", nil
+	}
+	return info.UserAccount.UserStatus, nil
+}
+
+// sendUserStatusChange sends the request with the admin token and returns the status sent by Cidaas, if any.
+func (u *CidaasUtils) sendUserStatusChange(ctx context.Context, sub string, operation string, init *RequestInit) (UserStatus, error) {
+	token, err := u.GetMyAccessToken()
+	if err != nil {
+		return "", err
+	}
+
+	init.Operation = operation
+	init.Token = token.Raw
+	init.Context = ctx
+
+	var result UserStatusResponse
+	err = u.doRequest(init, &result)
+	u.invalidateUserProfiles(ctx, sub)
+	if err != nil && !errors.Is(err, NoResultError) {
+		return "", err
+	}
+	return result.Data.UserStatus, nil
+}
@@ -0,0 +1,84 @@
+package cidaasutils
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCidaasUtils_UserLifecycle(t *testing.T) {
+	var requests []string
+	utils, server := mockAdminUtils(func(writer http.ResponseWriter, request *http.Request) {
+		requests = append(requests, request.Method+" "+request.URL.Path)
+		switch request.URL.Path {
+		case "/users-srv/user/sub-1/status":
+			var body UserStatusRequest
+			json.NewDecoder(request.Body).Decode(&body)
+			writer.Write([]byte(`{"success":true,"status":200,"data":{"userStatus":"` + string(body.Status) + `"}}`))
+		case "/users-srv/user/sub-1":
+			writer.WriteHeader(http.StatusNoContent)
+		case "/users-srv/internal/userinfo/profile/sub-1":
+			// the user was deactivated before it was locked
+			writer.Write([]byte(`{"success":true,"data":{"identity":{"sub":"sub-1"},"userAccount":{"userStatus":"INACTIVE"}}}`))
+		default:
+			writer.Write([]byte(`{"success":true,"status":200}`))
+		}
+	})
+	defer server.Close()
+
+	ctx := context.Background()
+	status, err := utils.DeactivateUser(ctx, "sub-1")
+	assert.Nil(t, err)
+	assert.Equal(t, UserStatusInactive, status)
+
+	status, err = utils.ActivateUser(ctx, "sub-1")
+	assert.Nil(t, err)
+	assert.Equal(t, UserStatusActive, status)
+
+	// without a status in the response, the status is read from the profile
+	status, err = utils.UnlockUser(ctx, "sub-1")
+	assert.Nil(t, err)
+	assert.Equal(t, UserStatusInactive, status)
+
+	status, err = utils.DeleteUser(ctx, "sub-1")
+	assert.Nil(t, err)
+	assert.Equal(t, UserStatusDeleted, status)
+
+	assert.Equal(t, []string{
+		"PUT /users-srv/user/sub-1/status",
+		"PUT /users-srv/user/sub-1/status",
+		"DELETE /users-srv/user/sub-1/lock",
+		"GET /users-srv/internal/userinfo/profile/sub-1",
+		"DELETE /users-srv/user/sub-1",
+	}, requests)
+}
+
+func TestCidaasUtils_LockUser_UnknownStatus(t *testing.T) {
+	utils, server := mockAdminUtils(func(writer http.ResponseWriter, request *http.Request) {
+		if request.Method == "POST" {
+			writer.Write([]byte(`{"success":true,"status":200}`))
+			return
+		}
+		writer.WriteHeader(http.StatusInternalServerError)
+	})
+	defer server.Close()
+
+	// the lock succeeded, but the status is not made up
+	status, err := utils.LockUser(context.Background(), "sub-1")
+	assert.Nil(t, err)
+	assert.Equal(t, UserStatus(""), status)
+}
+
+func TestCidaasUtils_DeleteUser_NotFound(t *testing.T) {
+	utils, server := mockAdminUtils(func(writer http.ResponseWriter, request *http.Request) {
+		writer.WriteHeader(http.StatusNotFound)
+	})
+	defer server.Close()
+
+	status, err := utils.DeleteUser(context.Background(), "unknown")
+	assert.True(t, IsNotFound(err))
+	assert.Equal(t, UserStatus(""), status)
+}
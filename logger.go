@@ -0,0 +1,206 @@
+package cidaasutils
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+)
+
+// Logger is used for all log output of the library.
+// The methods take a message followed by alternating keys and values,
+// which means that a *slog.Logger can be used directly.
+type Logger interface {
+	Debug(msg string, keysAndValues ...interface{})
+	Info(msg string, keysAndValues ...interface{})
+	Warn(msg string, keysAndValues ...interface{})
+	Error(msg string, keysAndValues ...interface{})
+}
+
+// NoopLogger discards all log output. It is used if no Logger is configured.
+type NoopLogger struct{}
+
+func (NoopLogger) Debug(string, ...interface{}) {}
+func (NoopLogger) Info(string, ...interface{})  {}
+func (NoopLogger) Warn(string, ...interface{})  {}
+func (NoopLogger) Error(string, ...interface{}) {}
+
+// LogLevel is the minimum level a StdLogger writes.
+type LogLevel int
+
+const (
+	LogLevelDebug LogLevel = iota
+	LogLevelInfo
+	LogLevelWarn
+	LogLevelError
+)
+
+func (l LogLevel) String() string {
+	switch l {
+	case LogLevelDebug:
+		return "DEBUG"
+	case LogLevelInfo:
+		return "INFO"
+	case LogLevelWarn:
+		return "WARN"
+	default:
+		return "ERROR"
+	}
+}
+
+// StdLogger writes key/value formatted lines to a stdlib *log.Logger,
+// e.g. `level=WARN msg="cidaas request failed" status=400`.
+type StdLogger struct {
+	logger *log.Logger
+	level  LogLevel
+}
+
+// NewStdLogger creates a Logger which writes all messages with at least the given level to logger.
+// If logger is nil the standard logger of the log package is used.
+func NewStdLogger(logger *log.Logger, level LogLevel) *StdLogger {
+	if logger == nil {
+		logger = log.Default()
+	}
+	return &StdLogger{logger: logger, level: level}
+}
+
+func (l *StdLogger) Debug(msg string, keysAndValues ...interface{}) {
+	l.log(LogLevelDebug, msg, keysAndValues)
+}
+
+func (l *StdLogger) Info(msg string, keysAndValues ...interface{}) {
+	l.log(LogLevelInfo, msg, keysAndValues)
+}
+
+func (l *StdLogger) Warn(msg string, keysAndValues ...interface{}) {
+	l.log(LogLevelWarn, msg, keysAndValues)
+}
+
+func (l *StdLogger) Error(msg string, keysAndValues ...interface{}) {
+	l.log(LogLevelError, msg, keysAndValues)
+}
+
+func (l *StdLogger) log(level LogLevel, msg string, keysAndValues []interface{}) {
+	if level < l.level {
+		return
+	}
+
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "level=%s msg=%q", level, msg)
+	for i := 0; i < len(keysAndValues); i += 2 {
+		key := fmt.Sprint(keysAndValues[i])
+		var value interface{} = "!MISSING"
+		if i+1 < len(keysAndValues) {
+			value = keysAndValues[i+1]
+		}
+		fmt.Fprintf(&builder, " %s=%s", key, formatLogValue(value))
+	}
+	l.logger.Print(builder.String())
+}
+
+func formatLogValue(value interface{}) string {
+	s := fmt.Sprint(value)
+	if s == "" || strings.ContainsAny(s, " \t\n\"=") {
+		return fmt.Sprintf("%q", s)
+	}
+	return s
+}
+
+// logger returns the configured logger or a NoopLogger.
+func (u *CidaasUtils) logger() Logger {
+	if u.options.Logger != nil {
+		return u.options.Logger
+	}
+	return NoopLogger{}
+}
+
+// redacted replaces all secret values in logged data.
+const redacted = "[REDACTED]"
+
+// sensitiveKeys are form fields, query parameters and json keys which are never logged.
+var sensitiveKeys = []string{
+	"access_token",
+	"refresh_token",
+	"id_token",
+	"token",
+	"code",
+	"device_code",
+	"password",
+	"client_secret",
+	"secret",
+	"authorization",
+}
+
+func isSensitiveKey(key string) bool {
+	key = strings.ToLower(key)
+	if strings.Contains(key, "password") || strings.Contains(key, "secret") || strings.HasSuffix(key, "_token") {
+		return true
+	}
+	return includesString(sensitiveKeys, key)
+}
+
+// redactValues returns a copy of values where all secrets are replaced.
+func redactValues(values url.Values) url.Values {
+	result := url.Values{}
+	for key, list := range values {
+		for _, value := range list {
+			if isSensitiveKey(key) {
+				value = redacted
+			}
+			result.Add(key, value)
+		}
+	}
+	return result
+}
+
+// redactURL returns the url as string with all secret query parameters replaced.
+func redactURL(u *url.URL) string {
+	if u == nil {
+		return ""
+	}
+	copied := *u
+	copied.User = nil
+	if copied.RawQuery != "" {
+		copied.RawQuery = redactValues(copied.Query()).Encode()
+	}
+	return copied.String()
+}
+
+// redactBody returns a loggable version of a request or response body.
+// JSON objects and form bodies are redacted key by key, other bodies are only logged by size.
+func redactBody(body []byte) string {
+	var object interface{}
+	if err := json.Unmarshal(body, &object); err == nil {
+		result, _ := json.Marshal(redactJSON(object))
+		return string(result)
+	}
+
+	if values, err := url.ParseQuery(string(body)); err == nil && strings.Contains(string(body), "=") {
+		return redactValues(values).Encode()
+	}
+
+	return fmt.Sprintf("<%d bytes>", len(body))
+}
+
+func redactJSON(value interface{}) interface{} {
+	switch typed := value.(type) {
+	case map[string]interface{}:
+		for key, inner := range typed {
+			// numeric codes are error codes and not secret
+			if _, isNumber := inner.(float64); isNumber && key == "code" {
+				continue
+			}
+			if isSensitiveKey(key) {
+				typed[key] = redacted
+			} else {
+				typed[key] = redactJSON(inner)
+			}
+		}
+	case []interface{}:
+		for i, inner := range typed {
+			typed[i] = redactJSON(inner)
+		}
+	}
+	return value
+}
@@ -1,8 +1,10 @@
 package cidaasutils
 
 import (
-	"log"
+	"context"
+	"io"
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/MicahParks/keyfunc"
@@ -25,24 +27,126 @@ type Options struct {
 	// Interval how often the JWKs will be refreshed from Cidaas.
 	// Default is one hour.
 	RefreshInterval time.Duration
+
+	// Logger receives all log output of the library.
+	// Default is a NoopLogger, a *slog.Logger or a StdLogger can be used here.
+	Logger Logger
+
+	// CircuitBreaker enables a circuit breaker per EndpointGroup.
+	// Requests to an open circuit fail fast with CircuitOpenError. Default is disabled.
+	CircuitBreaker *CircuitBreakerOptions
+
+	// RateLimits enables a client side token bucket per EndpointGroup.
+	// Requests wait for a free token unless the wait exceeds the deadline of their context,
+	// in which case they fail fast with RateLimitExceededError. Default is unlimited.
+	RateLimits map[EndpointGroup]RateLimit
+
+	// Middlewares wrap all requests to Cidaas, e.g. to add tracing headers or audit logging.
+	// The first middleware is the outermost one.
+	Middlewares []Middleware
+
+	// DenyList is consulted by ValidateJWT to reject tokens before they expire,
+	// e.g. tokens of sessions revoked with RevokeUserSessions. Default is disabled.
+	DenyList TokenDenyList
+
+	// ProfileCache enables a read-through cache for GetUserProfileInternally.
+	// Profiles changed through this library are invalidated automatically. Default is disabled.
+	ProfileCache *ProfileCacheOptions
+
+	// ConsentCache enables a short-lived cache for the consents checked by WithConsent and CheckConsents.
+	// Consents changed through this library are invalidated automatically. Default is disabled.
+	ConsentCache *ConsentCacheOptions
 }
 
 type ICidaasUtils interface {
 	Init() error
+	EndBackground()
 	ValidateJWT(token string) (*jwt.Token, error)
 	GetUserProfileInternally(sub string) (*UserInfo, error)
 	UpdateUserProfileInternally(sub string, info *UserUpdateRequest) error
+	InvalidateUserProfile(ctx context.Context, sub string) error
+	ListUserIdentities(ctx context.Context, sub string) ([]UserIdentity, error)
+	LinkIdentity(ctx context.Context, masterSub string, subToLink string) error
+	UnlinkIdentity(ctx context.Context, sub string, identityID string) error
+	SetPrimaryIdentity(ctx context.Context, sub string, identityID string) error
+	ApplyUserUpdate(ctx context.Context, sub string, mutate func(info *UserInfo) error) (bool, error)
+	CreateUser(ctx context.Context, request *UserCreateRequest) (string, error)
+	DeleteUser(ctx context.Context, sub string) (UserStatus, error)
+	DeactivateUser(ctx context.Context, sub string) (UserStatus, error)
+	ActivateUser(ctx context.Context, sub string) (UserStatus, error)
+	LockUser(ctx context.Context, sub string) (UserStatus, error)
+	UnlockUser(ctx context.Context, sub string) (UserStatus, error)
+	ChangePassword(ctx context.Context, accessToken string, oldPassword string, newPassword string) error
+	InitiatePasswordReset(ctx context.Context, medium ResetMedium, identifier string) (*PasswordReset, error)
+	CompletePasswordReset(ctx context.Context, reset *PasswordReset, code string, newPassword string) error
+	SetUserPassword(ctx context.Context, sub string, newPassword string) error
+	InitiateVerification(ctx context.Context, sub string, method VerificationMethod) (*Verification, error)
+	VerifyCode(ctx context.Context, verification *Verification, code string) error
+	GetVerificationStatus(ctx context.Context, sub string) (*VerificationStatus, error)
+	ListUserSessions(ctx context.Context, sub string) ([]UserSession, error)
+	RevokeUserSessions(ctx context.Context, sub string, sessionIDs ...string) error
+	SearchUsers(ctx context.Context, query *UserSearchQuery) *UserIterator
+	ExportUsers(ctx context.Context, w io.Writer, format BulkFormat, opts *BulkOptions) (*BulkResult, error)
+	ImportUsers(ctx context.Context, r io.Reader, format BulkFormat, opts *BulkOptions) (*BulkResult, error)
+	AddUserRole(ctx context.Context, sub string, role string) error
+	RemoveUserRole(ctx context.Context, sub string, role string) error
+	ListRoles(ctx context.Context) ([]Role, error)
+	CreateRole(ctx context.Context, role *Role) error
+	DeleteRole(ctx context.Context, role string) error
+	ListGroups(ctx context.Context) ([]Group, error)
+	GetGroup(ctx context.Context, groupID string) (*Group, error)
+	CreateGroup(ctx context.Context, group *Group) error
+	UpdateGroup(ctx context.Context, group *Group) error
+	DeleteGroup(ctx context.Context, groupID string) error
+	AddUserToGroup(ctx context.Context, groupID string, sub string, roles []string) error
+	RemoveUserFromGroup(ctx context.Context, groupID string, sub string) error
+	GetUserGroups(ctx context.Context, sub string) ([]GroupMembership, error)
+	ListConsents(ctx context.Context) ([]Consent, error)
+	GetUserConsents(ctx context.Context, sub string) ([]UserConsent, error)
+	AcceptConsent(ctx context.Context, sub string, consentID string, version string) error
+	WithdrawConsent(ctx context.Context, sub string, consentID string) error
+	CheckConsents(ctx context.Context, sub string, required map[string]string) error
+	ListApps(ctx context.Context) ([]ClientApp, error)
+	GetApp(ctx context.Context, clientID string) (*ClientApp, error)
+	CreateApp(ctx context.Context, app *ClientApp) (*ClientApp, error)
+	UpdateApp(ctx context.Context, app *ClientApp) error
+	DeleteApp(ctx context.Context, clientID string) error
+	RotateAppSecret(ctx context.Context, clientID string) (string, error)
+	ApplyApp(ctx context.Context, desired *ClientApp) (*ClientApp, []AppDrift, error)
 	JWTInterceptor(next http.Handler, options ...JWTInterceptorOption) http.Handler
+	WebhookHandler(options *WebhookOptions) *WebhookHandler
 	GetMyAccessToken() (*jwt.Token, error)
 	AuthorizationCodeFlow(code string, redirectURL string) (*AccessTokenResult, error)
 	RefreshTokenFlow(refreshToken string) (*AccessTokenResult, error)
+	ExchangeToken(ctx context.Context, subjectToken string, audience string, scopes []string, actorToken string) (*AccessTokenResult, error)
+	ClientCredentialsFlow(ctx context.Context, scopes []string) (*AccessTokenResult, error)
+	ServiceTransport(next http.RoundTripper, options *ServiceTransportOptions) http.RoundTripper
+	ForwardingTransport(next http.RoundTripper, options *ForwardingTransportOptions) http.RoundTripper
+	StartDeviceAuthorization(ctx context.Context, scopes []string) (*DeviceAuthorization, error)
+	PollDeviceToken(ctx context.Context, authorization *DeviceAuthorization) (*AccessTokenResult, error)
 }
 
 // CidaasUtils is the main struct for all utils functions.
 type CidaasUtils struct {
 	options       *Options
-	jwks          *keyfunc.JWKS
 	myAccessToken *jwt.Token
+	tokenMu       sync.Mutex
+
+	jwksMu      sync.RWMutex
+	jwks        *keyfunc.JWKS
+	stopRefresh chan struct{}
+
+	breakersMu sync.Mutex
+	breakers   map[EndpointGroup]*circuitBreaker
+
+	limitersMu sync.Mutex
+	limiters   map[EndpointGroup]*tokenBucket
+
+	profileCacheMu sync.Mutex
+	profiles       *profileCache
+
+	consentCacheMu sync.Mutex
+	consents       *consentCache
 }
 
 // making sure that the interface is implemented
@@ -54,29 +158,84 @@ func New(options *Options) *CidaasUtils {
 }
 
 // Init initializes the JWKs and sets up a refresh interval.
+// The refresh runs in the background until EndBackground is called.
 func (u *CidaasUtils) Init() error {
 	refreshInterval := time.Hour
 	if u.options.RefreshInterval != 0 {
 		refreshInterval = u.options.RefreshInterval
 	}
 
-	options := keyfunc.Options{
-		RefreshInterval: &refreshInterval,
-		RefreshErrorHandler: func(err error) {
-			log.Printf("There was an error with the jwt.KeyFunc\nError: %s", err.Error())
-		},
-	}
-
-	jwks, err := keyfunc.Get(u.buildUrl(jwkEndpoint), options)
+	jwks, err := u.fetchJWKs()
 	if err != nil {
 		return err
 	}
+
+	stop := make(chan struct{})
+	u.jwksMu.Lock()
+	if u.stopRefresh != nil {
+		close(u.stopRefresh)
+	}
 	u.jwks = jwks
+	u.stopRefresh = stop
+	u.jwksMu.Unlock()
 
+	go u.refreshJWKs(refreshInterval, stop)
 	return nil
 }
 
+// EndBackground stops the background refresh of the JWKs started by Init.
+func (u *CidaasUtils) EndBackground() {
+	u.jwksMu.Lock()
+	defer u.jwksMu.Unlock()
+	if u.stopRefresh != nil {
+		close(u.stopRefresh)
+		u.stopRefresh = nil
+	}
+}
+
+// fetchJWKs loads the JWKs once.
+// The background refresh of keyfunc is not used, it is started even if the first
+// request fails and then crashes because there are no JWKs.
+func (u *CidaasUtils) fetchJWKs() (*keyfunc.JWKS, error) {
+	return keyfunc.Get(u.buildUrl(jwkEndpoint), keyfunc.Options{Client: u.httpClient()})
+}
+
+// refreshJWKs replaces the JWKs in the given interval until stop is closed.
+// If a refresh fails, the previous JWKs are kept.
+func (u *CidaasUtils) refreshJWKs(interval time.Duration, stop chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+
+		jwks, err := u.fetchJWKs()
+		if err != nil {
+			u.logger().Error("there was an error with the jwt.KeyFunc", "error", err)
+			continue
+		}
+
+		u.jwksMu.Lock()
+		u.jwks = jwks
+		u.jwksMu.Unlock()
+	}
+}
+
+// keyFunc looks up the key of the token in the current JWKs.
+func (u *CidaasUtils) keyFunc(token *jwt.Token) (interface{}, error) {
+	u.jwksMu.RLock()
+	jwks := u.jwks
+	u.jwksMu.RUnlock()
+	return jwks.KeyFunc(token)
+}
+
 // InitWithJWKs initializes the JWKs without needing to talk to a server.
 func (u *CidaasUtils) InitWithJWKs(jwks *keyfunc.JWKS) {
+	u.jwksMu.Lock()
+	defer u.jwksMu.Unlock()
 	u.jwks = jwks
 }
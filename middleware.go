@@ -0,0 +1,58 @@
+package cidaasutils
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+// Middleware wraps the http.RoundTripper used for all requests to Cidaas, including the JWKs refresh.
+// RequestInitFromContext can be used to access the metadata of the request.
+type Middleware func(next http.RoundTripper) http.RoundTripper
+
+// RoundTripperFunc is an adapter to use an ordinary function as http.RoundTripper.
+type RoundTripperFunc func(request *http.Request) (*http.Response, error)
+
+func (f RoundTripperFunc) RoundTrip(request *http.Request) (*http.Response, error) {
+	return f(request)
+}
+
+// operationRefreshJWKs is the operation name of the requests done by the JWKs refresh.
+const operationRefreshJWKs = "RefreshJWKs"
+
+type requestInitContextKey struct{}
+
+func withRequestInit(ctx context.Context, init *RequestInit) context.Context {
+	return context.WithValue(ctx, requestInitContextKey{}, init)
+}
+
+// RequestInitFromContext returns the RequestInit of an outgoing Cidaas request if it exists otherwise nil.
+func RequestInitFromContext(ctx context.Context) *RequestInit {
+	init, ok := ctx.Value(requestInitContextKey{}).(*RequestInit)
+	if !ok {
+		return nil
+	}
+	return init
+}
+
+// applyMiddlewares wraps next with all configured middlewares, the first one being the outermost.
+func (u *CidaasUtils) applyMiddlewares(next http.RoundTripper) http.RoundTripper {
+	for i := len(u.options.Middlewares) - 1; i >= 0; i-- {
+		next = u.options.Middlewares[i](next)
+	}
+
+	return RoundTripperFunc(func(request *http.Request) (*http.Response, error) {
+		// requests which are not built by doRequest (e.g. the JWKs refresh) get their metadata here
+		if RequestInitFromContext(request.Context()) == nil {
+			init := &RequestInit{
+				Path:   strings.TrimPrefix(strings.TrimPrefix(request.URL.String(), u.options.BaseURL), "/"),
+				Method: request.Method,
+			}
+			if endpointGroupFor(request.URL.Path) == EndpointGroupJWKS {
+				init.Operation = operationRefreshJWKs
+			}
+			request = request.WithContext(withRequestInit(request.Context(), init))
+		}
+		return next.RoundTrip(request)
+	})
+}
@@ -0,0 +1,60 @@
+package cidaasutils
+
+import (
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCidaasUtils_Middlewares(t *testing.T) {
+	utils, server := mockServerUtils(func(writer http.ResponseWriter, request *http.Request) {
+		if request.URL.Path == "/"+jwkEndpoint {
+			writer.Write(testJwks)
+			return
+		}
+		assert.Equal(t, "correlation-1", request.Header.Get("X-Correlation-Id"))
+		assert.Equal(t, "my-agent", request.Header.Get("User-Agent"))
+		writer.Write([]byte(`{"success":true,"status":200}`))
+	})
+	defer server.Close()
+
+	var mu sync.Mutex
+	var operations []string
+	var order []string
+	utils.options.Middlewares = []Middleware{
+		func(next http.RoundTripper) http.RoundTripper {
+			return RoundTripperFunc(func(request *http.Request) (*http.Response, error) {
+				mu.Lock()
+				order = append(order, "first")
+				init := RequestInitFromContext(request.Context())
+				operations = append(operations, init.Operation+" "+init.Path)
+				mu.Unlock()
+				request.Header.Set("X-Correlation-Id", "correlation-1")
+				return next.RoundTrip(request)
+			})
+		},
+		func(next http.RoundTripper) http.RoundTripper {
+			return RoundTripperFunc(func(request *http.Request) (*http.Response, error) {
+				mu.Lock()
+				order = append(order, "second")
+				mu.Unlock()
+				request.Header.Set("User-Agent", "my-agent")
+				return next.RoundTrip(request)
+			})
+		},
+	}
+
+	assert.Nil(t, utils.Init())
+	defer utils.EndBackground()
+
+	var result SimpleStatusResponse
+	err := utils.doRequest(&RequestInit{Operation: "Test", Path: "users-srv/user/test", Method: "GET"}, &result)
+	assert.Nil(t, err)
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, []string{"RefreshJWKs " + jwkEndpoint, "Test users-srv/user/test"}, operations)
+	assert.Equal(t, []string{"first", "second", "first", "second"}, order)
+}
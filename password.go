@@ -0,0 +1,233 @@
+package cidaasutils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// InvalidCodeError is returned if a verification or reset code is wrong or expired.
+var InvalidCodeError = errors.New("code is invalid or expired")
+
+// CodeError is returned if Cidaas rejected a verification or reset code.
+// It matches InvalidCodeError with errors.Is and wraps the CidaasError.
+type CodeError struct {
+	// Message describes why the code was rejected, as far as Cidaas explained it.
+	Message string
+	// Err is the CidaasError of the rejected request.
+	Err error
+}
+
+func (e *CodeError) Error() string {
+	return fmt.Sprintf("%s: %s", InvalidCodeError, e.Message)
+}
+
+func (e *CodeError) Unwrap() error {
+	return e.Err
+}
+
+func (e *CodeError) Is(target error) bool {
+	return target == InvalidCodeError
+}
+
+// toCodeError converts Cidaas errors about wrong or expired codes.
+// Other errors, e.g. rate limits, are returned unchanged.
+func toCodeError(err error) error {
+	cidaasError := AsCidaasError(err)
+	if cidaasError == nil {
+		return err
+	}
+	switch cidaasError.StatusCode {
+	case http.StatusBadRequest, http.StatusNotFound, http.StatusGone:
+		return &CodeError{Message: cidaasError.Description(), Err: err}
+	}
+	return err
+}
+
+// PasswordPolicyError is returned if a new password does not fulfill the password policy of the tenant.
+type PasswordPolicyError struct {
+	// Message describes the violation, as far as Cidaas explained it.
+	Message string
+	// Err is the CidaasError if the policy was checked by Cidaas.
+	Err error
+}
+
+func (e *PasswordPolicyError) Error() string {
+	return fmt.Sprintf("password does not fulfill the password policy: %s", e.Message)
+}
+
+func (e *PasswordPolicyError) Unwrap() error {
+	return e.Err
+}
+
+// IsPasswordPolicyViolation reports whether a password was rejected because of the password policy.
+func IsPasswordPolicyViolation(err error) bool {
+	var policyError *PasswordPolicyError
+	return errors.As(err, &policyError)
+}
+
+// ResetMedium is the medium used to send the password reset code.
+type ResetMedium string
+
+const (
+	ResetMediumEmail ResetMedium = "email"
+	ResetMediumSMS   ResetMedium = "sms"
+)
+
+// PasswordReset is a started password reset which has to be completed with the code sent to the user.
+type PasswordReset struct {
+	ResetRequestID string      `json:"rprq"`
+	Medium         ResetMedium `json:"-"`
+}
+
+type changePasswordRequest struct {
+	Sub             string `json:"sub"`
+	OldPassword     string `json:"old_password"`
+	NewPassword     string `json:"new_password"`
+	ConfirmPassword string `json:"confirm_password"`
+}
+
+type setPasswordRequest struct {
+	Password        string `json:"password"`
+	ConfirmPassword string `json:"confirm_password"`
+}
+
+type resetPasswordInitiateRequest struct {
+	Email          string      `json:"email,omitempty"`
+	MobileNumber   string      `json:"mobile_number,omitempty"`
+	ResetMedium    ResetMedium `json:"resetMedium"`
+	ProcessingType string      `json:"processingType"`
+	ClientID       string      `json:"client_id"`
+}
+
+type resetPasswordInitiateResponse struct {
+	Success bool          `json:"success"`
+	Status  int           `json:"status"`
+	Data    PasswordReset `json:"data"`
+}
+
+type resetPasswordValidateRequest struct {
+	Code           string `json:"code"`
+	ResetRequestID string `json:"resetRequestId"`
+}
+
+type resetPasswordValidateResponse struct {
+	Success bool `json:"success"`
+	Status  int  `json:"status"`
+	Data    struct {
+		ExchangeID string `json:"exchangeId"`
+	} `json:"data"`
+}
+
+type resetPasswordAcceptRequest struct {
+	Password        string `json:"password"`
+	ConfirmPassword string `json:"confirmPassword"`
+	ExchangeID      string `json:"exchangeId"`
+	ResetRequestID  string `json:"resetRequestId"`
+}
+
+// ChangePassword changes the password of the user the access token belongs to.
+func (u *CidaasUtils) ChangePassword(ctx context.Context, accessToken string, oldPassword string, newPassword string) error {
+	if newPassword == "" {
+		return &PasswordPolicyError{Message: "password is empty"}
+	}
+
+	token, err := u.ValidateJWT(accessToken)
+	if err != nil {
+		return err
+	}
+	claims, err := toCidaasTokenClaims(token.Claims)
+	if err != nil {
+		return err
+	}
+
+	body := changePasswordRequest{Sub: claims.Sub, OldPassword: oldPassword, NewPassword: newPassword, ConfirmPassword: newPassword}
+
+	var result SimpleStatusResponse
+	err = u.doRequest(&RequestInit{Operation: "ChangePassword", Path: changePasswordEndpoint, Token: accessToken, Method: "POST", BodyJSON: body, Context: ctx}, &result)
+	return toPasswordPolicyError(err)
+}
+
+// InitiatePasswordReset starts the "forgot password" flow for the user with the given email or mobile number.
+// Cidaas sends a code via the medium, which has to be passed to CompletePasswordReset.
+func (u *CidaasUtils) InitiatePasswordReset(ctx context.Context, medium ResetMedium, identifier string) (*PasswordReset, error) {
+	body := resetPasswordInitiateRequest{ResetMedium: medium, ProcessingType: "CODE", ClientID: u.options.ClientID}
+	if medium == ResetMediumSMS {
+		body.MobileNumber = identifier
+	} else {
+		body.Email = identifier
+	}
+
+	var result resetPasswordInitiateResponse
+	err := u.doRequest(&RequestInit{Operation: "InitiatePasswordReset", Path: resetPasswordInitiateEndpoint, Method: "POST", BodyJSON: body, Context: ctx}, &result)
+	if err != nil {
+		return nil, err
+	}
+
+	result.Data.Medium = medium
+	return &result.Data, nil
+}
+
+// CompletePasswordReset validates the code of the reset and sets the new password.
+func (u *CidaasUtils) CompletePasswordReset(ctx context.Context, reset *PasswordReset, code string, newPassword string) error {
+	if newPassword == "" {
+		return &PasswordPolicyError{Message: "password is empty"}
+	}
+
+	var validated resetPasswordValidateResponse
+	err := u.doRequest(&RequestInit{
+		Operation: "CompletePasswordReset",
+		Path:      resetPasswordValidateEndpoint,
+		Method:    "POST",
+		BodyJSON:  resetPasswordValidateRequest{Code: code, ResetRequestID: reset.ResetRequestID},
+		Context:   ctx,
+	}, &validated)
+	if err != nil {
+		return toCodeError(err)
+	}
+
+	var result SimpleStatusResponse
+	err = u.doRequest(&RequestInit{
+		Operation: "CompletePasswordReset",
+		Path:      resetPasswordAcceptEndpoint,
+		Method:    "POST",
+		BodyJSON: resetPasswordAcceptRequest{
+			Password:        newPassword,
+			ConfirmPassword: newPassword,
+			ExchangeID:      validated.Data.ExchangeID,
+			ResetRequestID:  reset.ResetRequestID,
+		},
+		Context: ctx,
+	}, &result)
+	return toPasswordPolicyError(err)
+}
+
+// SetUserPassword sets a new password for the user using the admin credentials.
+func (u *CidaasUtils) SetUserPassword(ctx context.Context, sub string, newPassword string) error {
+	if newPassword == "" {
+		return &PasswordPolicyError{Message: "password is empty"}
+	}
+
+	path := strings.Replace(userPasswordEndpoint, "{sub}", sub, 1)
+	body := setPasswordRequest{Password: newPassword, ConfirmPassword: newPassword}
+	err := u.doAdminRequest(&RequestInit{Operation: "SetUserPassword", Path: path, Method: "PUT", BodyJSON: body, Context: ctx})
+	return toPasswordPolicyError(err)
+}
+
+// toPasswordPolicyError converts Cidaas errors about rejected passwords.
+func toPasswordPolicyError(err error) error {
+	cidaasError := AsCidaasError(err)
+	if cidaasError == nil || cidaasError.StatusCode >= 500 || cidaasError.StatusCode == http.StatusUnauthorized {
+		return err
+	}
+
+	description := strings.ToLower(cidaasError.Description())
+	code := strings.ToLower(cidaasError.Code())
+	if strings.Contains(description, "policy") || strings.Contains(code, "policy") ||
+		(strings.Contains(description, "password") && (strings.Contains(description, "weak") || strings.Contains(description, "length"))) {
+		return &PasswordPolicyError{Message: cidaasError.Description(), Err: err}
+	}
+	return err
+}
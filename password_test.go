@@ -0,0 +1,101 @@
+package cidaasutils
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCidaasUtils_ChangePassword(t *testing.T) {
+	var userToken string
+	utils, server := mockAdminUtils(func(writer http.ResponseWriter, request *http.Request) {
+		assert.Equal(t, "/"+changePasswordEndpoint, request.URL.Path)
+		assert.Equal(t, "Bearer "+userToken, request.Header.Get("Authorization"))
+
+		var body changePasswordRequest
+		json.NewDecoder(request.Body).Decode(&body)
+		assert.Equal(t, "user-1", body.Sub)
+		if body.NewPassword == "weak" {
+			writer.WriteHeader(http.StatusBadRequest)
+			writer.Write([]byte(`{"success":false,"status":400,"error":{"code":"PASSWORD_POLICY","error":"password does not match the policy"}}`))
+			return
+		}
+		writer.Write([]byte(`{"success":true,"status":200}`))
+	})
+	defer server.Close()
+	userToken = signTestToken(jwt.MapClaims{"iss": server.URL, "sub": "user-1", "exp": time.Now().Add(time.Hour).Unix()})
+
+	ctx := context.Background()
+	assert.Nil(t, utils.ChangePassword(ctx, userToken, "old", "Very-Strong-1"))
+
+	err := utils.ChangePassword(ctx, userToken, "old", "weak")
+	assert.True(t, IsPasswordPolicyViolation(err))
+	assert.Equal(t, 400, AsCidaasError(err).StatusCode)
+
+	assert.True(t, IsPasswordPolicyViolation(utils.ChangePassword(ctx, userToken, "old", "")))
+	assert.Equal(t, TokenInvalidError, utils.ChangePassword(ctx, "invalid", "old", "new"))
+}
+
+func TestCidaasUtils_PasswordReset(t *testing.T) {
+	utils, server := mockAdminUtils(func(writer http.ResponseWriter, request *http.Request) {
+		var body map[string]interface{}
+		json.NewDecoder(request.Body).Decode(&body)
+
+		switch request.URL.Path {
+		case "/" + resetPasswordInitiateEndpoint:
+			assert.Equal(t, "+4912345", body["mobile_number"])
+			assert.Equal(t, "sms", body["resetMedium"])
+			writer.Write([]byte(`{"success":true,"status":200,"data":{"rprq":"reset-1"}}`))
+		case "/" + resetPasswordValidateEndpoint:
+			if body["code"] == "throttled" {
+				writer.WriteHeader(http.StatusTooManyRequests)
+				writer.Write([]byte(`{"error":"too_many_requests"}`))
+				return
+			}
+			if body["code"] != "123456" {
+				writer.WriteHeader(http.StatusBadRequest)
+				writer.Write([]byte(`{"success":false,"status":400,"error":"invalid code"}`))
+				return
+			}
+			writer.Write([]byte(`{"success":true,"status":200,"data":{"exchangeId":"exchange-1"}}`))
+		case "/" + resetPasswordAcceptEndpoint:
+			assert.Equal(t, "exchange-1", body["exchangeId"])
+			assert.Equal(t, "reset-1", body["resetRequestId"])
+			writer.Write([]byte(`{"success":true,"status":200}`))
+		}
+	})
+	defer server.Close()
+
+	ctx := context.Background()
+	reset, err := utils.InitiatePasswordReset(ctx, ResetMediumSMS, "+4912345")
+	assert.Nil(t, err)
+	assert.Equal(t, &PasswordReset{ResetRequestID: "reset-1", Medium: ResetMediumSMS}, reset)
+
+	err = utils.CompletePasswordReset(ctx, reset, "000000", "Very-Strong-1")
+	assert.True(t, errors.Is(err, InvalidCodeError))
+	assert.Equal(t, 400, AsCidaasError(err).StatusCode)
+
+	// throttled requests are no wrong codes
+	err = utils.CompletePasswordReset(ctx, reset, "throttled", "Very-Strong-1")
+	assert.True(t, IsRateLimited(err))
+	assert.False(t, errors.Is(err, InvalidCodeError))
+
+	assert.Nil(t, utils.CompletePasswordReset(ctx, reset, "123456", "Very-Strong-1"))
+}
+
+func TestCidaasUtils_SetUserPassword(t *testing.T) {
+	utils, server := mockAdminUtils(func(writer http.ResponseWriter, request *http.Request) {
+		assert.Equal(t, "PUT", request.Method)
+		assert.Equal(t, "/users-srv/user/sub-1/password", request.URL.Path)
+		writer.Write([]byte(`{"success":true,"status":200}`))
+	})
+	defer server.Close()
+
+	assert.Nil(t, utils.SetUserPassword(context.Background(), "sub-1", "Very-Strong-1"))
+}
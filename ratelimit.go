@@ -0,0 +1,158 @@
+package cidaasutils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// RateLimitExceededError is returned if a request would have to wait for the
+// client side rate limiter longer than the deadline of its context allows.
+var RateLimitExceededError = errors.New("cidaas client side rate limit exceeded")
+
+// RateLimit configures the token bucket of an EndpointGroup.
+type RateLimit struct {
+	// Number of requests per second.
+	Rate float64
+
+	// Number of requests which may be sent at once. Default is 1.
+	Burst int
+}
+
+// tokenBucket is a simple token bucket which also honours the Retry-After of 429 responses.
+type tokenBucket struct {
+	rate  float64
+	burst float64
+	now   func() time.Time
+
+	mu          sync.Mutex
+	tokens      float64
+	last        time.Time
+	pausedUntil time.Time
+}
+
+func newTokenBucket(limit RateLimit) *tokenBucket {
+	burst := float64(limit.Burst)
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{rate: limit.Rate, burst: burst, tokens: burst, now: time.Now}
+}
+
+// reserve takes a token and returns how long the caller has to wait before sending the request.
+// No token is taken if the wait would exceed the deadline.
+func (b *tokenBucket) reserve(deadline time.Time, hasDeadline bool) (time.Duration, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now()
+	if !b.last.IsZero() {
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+	}
+	b.last = now
+
+	var wait time.Duration
+	if b.tokens < 1 {
+		wait = time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+	}
+	if b.pausedUntil.After(now.Add(wait)) {
+		wait = b.pausedUntil.Sub(now)
+	}
+
+	if hasDeadline && now.Add(wait).After(deadline) {
+		return 0, RateLimitExceededError
+	}
+
+	b.tokens--
+	return wait, nil
+}
+
+// cancel gives back a reserved token which was not used.
+func (b *tokenBucket) cancel() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens++
+}
+
+// pause stops all requests of the bucket for the given duration.
+func (b *tokenBucket) pause(duration time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	until := b.now().Add(duration)
+	if until.After(b.pausedUntil) {
+		b.pausedUntil = until
+	}
+	b.tokens = 0
+}
+
+// wait blocks until a request may be sent or fails fast with RateLimitExceededError.
+func (b *tokenBucket) wait(ctx context.Context) error {
+	deadline, hasDeadline := ctx.Deadline()
+	wait, err := b.reserve(deadline, hasDeadline)
+	if err != nil {
+		return err
+	}
+	if wait <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		b.cancel()
+		return ctx.Err()
+	}
+}
+
+// rateLimiter returns the token bucket for the group or nil if the group is not limited.
+func (u *CidaasUtils) rateLimiter(group EndpointGroup) *tokenBucket {
+	limit, ok := u.options.RateLimits[group]
+	if !ok || limit.Rate <= 0 {
+		return nil
+	}
+
+	u.limitersMu.Lock()
+	defer u.limitersMu.Unlock()
+	if u.limiters == nil {
+		u.limiters = map[EndpointGroup]*tokenBucket{}
+	}
+	limiter, ok := u.limiters[group]
+	if !ok {
+		limiter = newTokenBucket(limit)
+		u.limiters[group] = limiter
+	}
+	return limiter
+}
+
+// applyRateLimit waits for the limiter of the group and pauses it if Cidaas answered with 429.
+func (u *CidaasUtils) applyRateLimit(request *http.Request, next func() (*http.Response, error)) (*http.Response, error) {
+	group := endpointGroupFor(request.URL.Path)
+	limiter := u.rateLimiter(group)
+	if limiter == nil {
+		return next()
+	}
+
+	if err := limiter.wait(request.Context()); err != nil {
+		return nil, fmt.Errorf("%w: %s", err, group)
+	}
+
+	resp, err := next()
+	if err == nil && resp.StatusCode == http.StatusTooManyRequests {
+		retryAfter := parseRetryAfter(resp.Header.Get("Retry-After"))
+		if retryAfter <= 0 {
+			retryAfter = time.Second
+		}
+		u.logger().Warn("cidaas rate limit reached, pausing requests", "group", group, "retry_after", retryAfter)
+		limiter.pause(retryAfter)
+	}
+	return resp, err
+}
@@ -0,0 +1,87 @@
+package cidaasutils
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenBucket_Reserve(t *testing.T) {
+	now := time.Now()
+	bucket := newTokenBucket(RateLimit{Rate: 2, Burst: 2})
+	bucket.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		wait, err := bucket.reserve(time.Time{}, false)
+		assert.Nil(t, err)
+		assert.Equal(t, time.Duration(0), wait)
+	}
+
+	wait, err := bucket.reserve(time.Time{}, false)
+	assert.Nil(t, err)
+	assert.Equal(t, 500*time.Millisecond, wait)
+
+	// the next token would be available after one second which is after the deadline
+	_, err = bucket.reserve(now.Add(500*time.Millisecond), true)
+	assert.Equal(t, RateLimitExceededError, err)
+
+	now = now.Add(time.Second)
+	wait, err = bucket.reserve(now, true)
+	assert.Nil(t, err)
+	assert.Equal(t, time.Duration(0), wait)
+}
+
+func TestTokenBucket_Pause(t *testing.T) {
+	now := time.Now()
+	bucket := newTokenBucket(RateLimit{Rate: 100, Burst: 10})
+	bucket.now = func() time.Time { return now }
+
+	bucket.pause(3 * time.Second)
+	wait, err := bucket.reserve(time.Time{}, false)
+	assert.Nil(t, err)
+	assert.Equal(t, 3*time.Second, wait)
+}
+
+func TestCidaasUtils_RateLimitFailFast(t *testing.T) {
+	calls := 0
+	utils, server := mockServerUtils(func(writer http.ResponseWriter, request *http.Request) {
+		calls++
+		writer.Write([]byte(`{"success":true,"status":200}`))
+	})
+	defer server.Close()
+	utils.options.RateLimits = map[EndpointGroup]RateLimit{EndpointGroupUsers: {Rate: 0.01}}
+
+	var result SimpleStatusResponse
+	err := utils.doRequest(&RequestInit{Path: "users-srv/user/test", Method: "GET"}, &result)
+	assert.Nil(t, err)
+
+	// the next token is only available in 100 seconds, which exceeds the request timeout
+	err = utils.doRequest(&RequestInit{Path: "users-srv/user/test", Method: "GET"}, &result)
+	assert.True(t, errors.Is(err, RateLimitExceededError))
+	assert.Equal(t, 1, calls)
+
+	// other groups are not limited
+	assert.Nil(t, utils.rateLimiter(EndpointGroupToken))
+}
+
+func TestCidaasUtils_RateLimitHonoursRetryAfter(t *testing.T) {
+	utils, server := mockServerUtils(func(writer http.ResponseWriter, request *http.Request) {
+		writer.Header().Set("Retry-After", "60")
+		writer.WriteHeader(http.StatusTooManyRequests)
+	})
+	defer server.Close()
+	utils.options.RateLimits = map[EndpointGroup]RateLimit{EndpointGroupUsers: {Rate: 100, Burst: 100}}
+
+	var result SimpleStatusResponse
+	err := utils.doRequest(&RequestInit{Path: "users-srv/user/test", Method: "GET"}, &result)
+	assert.True(t, IsRateLimited(err))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	err = utils.doRequest(&RequestInit{Path: "users-srv/user/test", Method: "GET", Context: ctx}, &result)
+	assert.True(t, errors.Is(err, RateLimitExceededError))
+}
@@ -0,0 +1,119 @@
+package cidaasutils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// RoleNotFoundError is returned if a role is not defined in the tenant.
+var RoleNotFoundError = errors.New("role does not exist")
+
+// InvalidRoleError is returned if an empty role name is given.
+var InvalidRoleError = errors.New("role is invalid")
+
+// Role is a role definition of the tenant.
+type Role struct {
+	Role        string `json:"role"`
+	Name        string `json:"name,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+type RolesResponse struct {
+	Success bool   `json:"success"`
+	Status  int    `json:"status"`
+	Data    []Role `json:"data"`
+}
+
+type userRoleRequest struct {
+	Role string `json:"role"`
+}
+
+// AddUserRole assigns the role to the user. Assigning a role the user already has is not an error.
+func (u *CidaasUtils) AddUserRole(ctx context.Context, sub string, role string) error {
+	if role == "" {
+		return InvalidRoleError
+	}
+
+	path := strings.Replace(userRolesEndpoint, "{sub}", sub, 1)
+	defer u.invalidateUserProfiles(ctx, sub)
+	err := u.doAdminRequest(&RequestInit{Operation: "AddUserRole", Path: path, Method: "POST", BodyJSON: userRoleRequest{Role: role}, Context: ctx})
+	if hasStatus(err, http.StatusConflict) {
+		return nil
+	}
+	if isRoleNotFound(err) {
+		return fmt.Errorf("%w: %s", RoleNotFoundError, role)
+	}
+	return err
+}
+
+// RemoveUserRole revokes the role from the user. Revoking a role the user does not have is not an error.
+func (u *CidaasUtils) RemoveUserRole(ctx context.Context, sub string, role string) error {
+	if role == "" {
+		return InvalidRoleError
+	}
+
+	path := strings.Replace(userRoleEndpoint, "{sub}", sub, 1)
+	path = strings.Replace(path, "{role}", url.PathEscape(role), 1)
+	defer u.invalidateUserProfiles(ctx, sub)
+	err := u.doAdminRequest(&RequestInit{Operation: "RemoveUserRole", Path: path, Method: "DELETE", Context: ctx})
+	if isRoleNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// ListRoles returns all roles defined in the tenant.
+func (u *CidaasUtils) ListRoles(ctx context.Context) ([]Role, error) {
+	token, err := u.GetMyAccessToken()
+	if err != nil {
+		return nil, err
+	}
+
+	var result RolesResponse
+	err = u.doRequest(&RequestInit{Operation: "ListRoles", Path: rolesEndpoint, Token: token.Raw, Context: ctx}, &result)
+	if errors.Is(err, NoResultError) {
+		return []Role{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return result.Data, nil
+}
+
+// CreateRole defines a new role in the tenant. Creating an already existing role is not an error.
+func (u *CidaasUtils) CreateRole(ctx context.Context, role *Role) error {
+	if role.Role == "" {
+		return InvalidRoleError
+	}
+
+	err := u.doAdminRequest(&RequestInit{Operation: "CreateRole", Path: rolesEndpoint, Method: "POST", BodyJSON: role, Context: ctx})
+	if hasStatus(err, http.StatusConflict) {
+		return nil
+	}
+	return err
+}
+
+// DeleteRole deletes the role definition. Deleting a role which does not exist is not an error.
+func (u *CidaasUtils) DeleteRole(ctx context.Context, role string) error {
+	if role == "" {
+		return InvalidRoleError
+	}
+
+	path := strings.Replace(roleEndpoint, "{role}", url.PathEscape(role), 1)
+	err := u.doAdminRequest(&RequestInit{Operation: "DeleteRole", Path: path, Method: "DELETE", Context: ctx})
+	if IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// isRoleNotFound reports whether Cidaas answered with 404 because of the role and not because of the user.
+func isRoleNotFound(err error) bool {
+	cidaasError := AsCidaasError(err)
+	return cidaasError != nil && cidaasError.StatusCode == http.StatusNotFound &&
+		strings.Contains(strings.ToLower(cidaasError.Description()), "role")
+}
@@ -0,0 +1,65 @@
+package cidaasutils
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCidaasUtils_UserRoles(t *testing.T) {
+	utils, server := mockAdminUtils(func(writer http.ResponseWriter, request *http.Request) {
+		switch request.Method + " " + request.URL.Path {
+		case "POST /users-srv/user/sub-1/roles":
+			// role already assigned
+			writer.WriteHeader(http.StatusConflict)
+		case "POST /users-srv/user/sub-2/roles":
+			writer.WriteHeader(http.StatusNotFound)
+			writer.Write([]byte(`{"success":false,"status":404,"error":"role not found"}`))
+		case "DELETE /users-srv/user/sub-1/roles/ADMIN":
+			writer.WriteHeader(http.StatusNotFound)
+			writer.Write([]byte(`{"success":false,"status":404,"error":"user does not have the role"}`))
+		case "DELETE /users-srv/user/unknown/roles/ADMIN":
+			writer.WriteHeader(http.StatusNotFound)
+			writer.Write([]byte(`{"success":false,"status":404,"error":"user not found"}`))
+		default:
+			t.Errorf("unexpected request %s %s", request.Method, request.URL.Path)
+		}
+	})
+	defer server.Close()
+
+	ctx := context.Background()
+	assert.Nil(t, utils.AddUserRole(ctx, "sub-1", "ADMIN"))
+	assert.True(t, errors.Is(utils.AddUserRole(ctx, "sub-2", "UNKNOWN"), RoleNotFoundError))
+	assert.Equal(t, InvalidRoleError, utils.AddUserRole(ctx, "sub-1", ""))
+
+	assert.Nil(t, utils.RemoveUserRole(ctx, "sub-1", "ADMIN"))
+	assert.True(t, IsNotFound(utils.RemoveUserRole(ctx, "unknown", "ADMIN")))
+}
+
+func TestCidaasUtils_RoleDefinitions(t *testing.T) {
+	utils, server := mockAdminUtils(func(writer http.ResponseWriter, request *http.Request) {
+		switch request.Method + " " + request.URL.Path {
+		case "GET /roles-srv/roles":
+			writer.Write([]byte(`{"success":true,"status":200,"data":[{"role":"ADMIN","name":"Admin"},{"role":"USER"}]}`))
+		case "POST /roles-srv/roles":
+			writer.WriteHeader(http.StatusConflict)
+		case "DELETE /roles-srv/roles/OLD":
+			writer.WriteHeader(http.StatusNotFound)
+		default:
+			t.Errorf("unexpected request %s %s", request.Method, request.URL.Path)
+		}
+	})
+	defer server.Close()
+
+	ctx := context.Background()
+	roles, err := utils.ListRoles(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, []Role{{Role: "ADMIN", Name: "Admin"}, {Role: "USER"}}, roles)
+
+	assert.Nil(t, utils.CreateRole(ctx, &Role{Role: "ADMIN"}))
+	assert.Nil(t, utils.DeleteRole(ctx, "OLD"))
+	assert.Equal(t, InvalidRoleError, utils.CreateRole(ctx, &Role{}))
+}
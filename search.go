@@ -0,0 +1,152 @@
+package cidaasutils
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// defaultSearchPageSize is the number of users fetched per page if the query does not specify it.
+const defaultSearchPageSize = 50
+
+// UserSearchQuery filters the users returned by SearchUsers. Empty fields are ignored.
+type UserSearchQuery struct {
+	Email        string                 `json:"email,omitempty"`
+	MobileNumber string                 `json:"mobile_number,omitempty"`
+	Role         string                 `json:"role,omitempty"`
+	GroupID      string                 `json:"groupId,omitempty"`
+	CustomFields map[string]interface{} `json:"customFields,omitempty"`
+	CreatedFrom  *time.Time             `json:"createdFrom,omitempty"`
+	CreatedTo    *time.Time             `json:"createdTo,omitempty"`
+
+	// PageSize is the number of users fetched with one request. Default is 50.
+	PageSize int `json:"-"`
+}
+
+type userSearchRequest struct {
+	*UserSearchQuery
+	Offset int `json:"offset"`
+	Limit  int `json:"limit"`
+}
+
+type UserSearchResponse struct {
+	Success bool       `json:"success"`
+	Status  int        `json:"status"`
+	Total   int        `json:"total"`
+	Data    []UserInfo `json:"data"`
+}
+
+// UserIterator lazily pages through the results of SearchUsers.
+//
+//	it := utils.SearchUsers(ctx, &UserSearchQuery{Role: "ADMIN"})
+//	for it.Next() {
+//		user := it.User()
+//	}
+//	if err := it.Err(); err != nil {
+//		...
+//	}
+type UserIterator struct {
+	utils *CidaasUtils
+	ctx   context.Context
+	query *UserSearchQuery
+
+	page   []UserInfo
+	index  int
+	offset int
+	total  int
+	last   bool
+	err    error
+}
+
+// SearchUsers returns an iterator over all users matching the query.
+// Pages are only fetched when needed, the iterator stops if ctx is cancelled.
+func (u *CidaasUtils) SearchUsers(ctx context.Context, query *UserSearchQuery) *UserIterator {
+	if query == nil {
+		query = &UserSearchQuery{}
+	}
+	return &UserIterator{utils: u, ctx: ctx, query: query, index: -1}
+}
+
+// Next advances to the next user. It returns false if there are no more users or an error occurred.
+func (i *UserIterator) Next() bool {
+	if i.err != nil {
+		return false
+	}
+
+	i.index++
+	if i.index < len(i.page) {
+		return true
+	}
+	if i.last {
+		return false
+	}
+
+	if err := i.ctx.Err(); err != nil {
+		i.err = err
+		return false
+	}
+
+	if err := i.fetch(); err != nil {
+		i.err = err
+		return false
+	}
+	i.index = 0
+	return len(i.page) > 0
+}
+
+// User returns the current user.
+func (i *UserIterator) User() *UserInfo {
+	if i.index < 0 || i.index >= len(i.page) {
+		return nil
+	}
+	return &i.page[i.index]
+}
+
+// Err returns the error which stopped the iteration, if any.
+func (i *UserIterator) Err() error {
+	return i.err
+}
+
+// Total returns the total number of matching users as reported by Cidaas.
+// It is only known after the first call to Next.
+func (i *UserIterator) Total() int {
+	return i.total
+}
+
+func (i *UserIterator) fetch() error {
+	token, err := i.utils.GetMyAccessToken()
+	if err != nil {
+		return err
+	}
+
+	pageSize := i.query.PageSize
+	if pageSize <= 0 {
+		pageSize = defaultSearchPageSize
+	}
+
+	var result UserSearchResponse
+	err = i.utils.doRequest(&RequestInit{
+		Operation: "SearchUsers",
+		Path:      userSearchEndpoint,
+		Token:     token.Raw,
+		Method:    "POST",
+		BodyJSON:  userSearchRequest{UserSearchQuery: i.query, Offset: i.offset, Limit: pageSize},
+		Context:   i.ctx,
+	}, &result)
+	if errors.Is(err, NoResultError) {
+		result = UserSearchResponse{}
+	} else if err != nil {
+		return err
+	}
+
+	i.page = result.Data
+	i.offset += len(result.Data)
+	i.total = result.Total
+	if result.Total > 0 {
+		// Cidaas may return less users than requested, only the total tells when all were read
+		i.last = i.offset >= result.Total || len(result.Data) == 0
+	} else {
+		i.last = len(result.Data) == 0
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package cidaasutils
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCidaasUtils_SearchUsers(t *testing.T) {
+	var requests []map[string]interface{}
+	utils, server := mockAdminUtils(func(writer http.ResponseWriter, request *http.Request) {
+		assert.Equal(t, "/"+userSearchEndpoint, request.URL.Path)
+		var body map[string]interface{}
+		json.NewDecoder(request.Body).Decode(&body)
+		requests = append(requests, body)
+
+		offset := int(body["offset"].(float64))
+		var users []string
+		for i := offset; i < offset+2 && i < 5; i++ {
+			users = append(users, fmt.Sprintf(`{"identity":{"sub":"sub-%d"},"roles":["ADMIN"]}`, i))
+		}
+		writer.Write([]byte(fmt.Sprintf(`{"success":true,"status":200,"total":5,"data":[%s]}`, strings.Join(users, ","))))
+	})
+	defer server.Close()
+
+	it := utils.SearchUsers(context.Background(), &UserSearchQuery{Role: "ADMIN", PageSize: 2})
+	var subs []string
+	for it.Next() {
+		subs = append(subs, it.User().Identity.Sub)
+	}
+	assert.Nil(t, it.Err())
+	assert.Equal(t, []string{"sub-0", "sub-1", "sub-2", "sub-3", "sub-4"}, subs)
+	assert.Equal(t, 5, it.Total())
+
+	assert.Len(t, requests, 3)
+	assert.Equal(t, "ADMIN", requests[0]["role"])
+	assert.Equal(t, 2.0, requests[0]["limit"])
+	assert.Equal(t, 4.0, requests[2]["offset"])
+}
+
+func TestCidaasUtils_SearchUsers_CappedPageSize(t *testing.T) {
+	calls := 0
+	utils, server := mockAdminUtils(func(writer http.ResponseWriter, request *http.Request) {
+		var body map[string]interface{}
+		json.NewDecoder(request.Body).Decode(&body)
+		calls++
+
+		// Cidaas returns at most 2 users per page, whatever limit was requested
+		offset := int(body["offset"].(float64))
+		var users []string
+		for i := offset; i < offset+2 && i < 5; i++ {
+			users = append(users, fmt.Sprintf(`{"identity":{"sub":"sub-%d"}}`, i))
+		}
+		writer.Write([]byte(fmt.Sprintf(`{"success":true,"status":200,"total":5,"data":[%s]}`, strings.Join(users, ","))))
+	})
+	defer server.Close()
+
+	it := utils.SearchUsers(context.Background(), &UserSearchQuery{PageSize: 5})
+	count := 0
+	for it.Next() {
+		count++
+	}
+	assert.Nil(t, it.Err())
+	assert.Equal(t, 5, count)
+	assert.Equal(t, 3, calls)
+}
+
+func TestCidaasUtils_SearchUsers_Cancelled(t *testing.T) {
+	calls := 0
+	utils, server := mockAdminUtils(func(writer http.ResponseWriter, request *http.Request) {
+		calls++
+		writer.Write([]byte(`{"success":true,"status":200,"total":4,"data":[{"identity":{"sub":"a"}},{"identity":{"sub":"b"}}]}`))
+	})
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	it := utils.SearchUsers(ctx, &UserSearchQuery{PageSize: 2})
+	assert.True(t, it.Next())
+	cancel()
+	assert.True(t, it.Next())
+	assert.False(t, it.Next())
+	assert.Equal(t, context.Canceled, it.Err())
+	assert.Equal(t, 1, calls)
+}
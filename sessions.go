@@ -0,0 +1,149 @@
+package cidaasutils
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+// UserSession is an active session of a user.
+type UserSession struct {
+	SessionID    string    `json:"sid"`
+	Sub          string    `json:"sub"`
+	ClientID     string    `json:"clientId,omitempty"`
+	DeviceID     string    `json:"deviceId,omitempty"`
+	DeviceName   string    `json:"deviceName,omitempty"`
+	UserAgent    string    `json:"userAgent,omitempty"`
+	IPAddress    string    `json:"ipAddress,omitempty"`
+	CreatedTime  Timestamp `json:"createdTime"`
+	LastUsedTime Timestamp `json:"lastUsedTime"`
+}
+
+type UserSessionsResponse struct {
+	Success bool          `json:"success"`
+	Status  int           `json:"status"`
+	Data    []UserSession `json:"data"`
+}
+
+type revokeSessionsRequest struct {
+	SessionIDs []string `json:"sessionIds,omitempty"`
+}
+
+// ListUserSessions returns all active sessions of the user.
+func (u *CidaasUtils) ListUserSessions(ctx context.Context, sub string) ([]UserSession, error) {
+	token, err := u.GetMyAccessToken()
+	if err != nil {
+		return nil, err
+	}
+
+	path := strings.Replace(userSessionsEndpoint, "{sub}", sub, 1)
+
+	var result UserSessionsResponse
+	err = u.doRequest(&RequestInit{Operation: "ListUserSessions", Path: path, Token: token.Raw, Context: ctx}, &result)
+	if errors.Is(err, NoResultError) {
+		return []UserSession{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return result.Data, nil
+}
+
+// RevokeUserSessions ends the given sessions of the user or all sessions if no session id is given.
+// If the configured DenyList implements SessionRevoker, it is informed as well, so that
+// ValidateJWT rejects the tokens of the sessions before they expire.
+func (u *CidaasUtils) RevokeUserSessions(ctx context.Context, sub string, sessionIDs ...string) error {
+	path := strings.Replace(userSessionsRevokeEndpoint, "{sub}", sub, 1)
+	body := revokeSessionsRequest{SessionIDs: sessionIDs}
+	err := u.doAdminRequest(&RequestInit{Operation: "RevokeUserSessions", Path: path, Method: "POST", BodyJSON: body, Context: ctx})
+	if err != nil {
+		return err
+	}
+
+	if revoker, ok := u.options.DenyList.(SessionRevoker); ok {
+		revoker.RevokeSessions(sub, sessionIDs)
+	}
+	return nil
+}
+
+// TokenDenyList rejects tokens before they expire.
+type TokenDenyList interface {
+	// IsDenied reports whether the token with the given claims must be rejected.
+	IsDenied(claims *CidaasTokenClaims) bool
+}
+
+// SessionRevoker can be implemented by a TokenDenyList to be informed about revoked sessions.
+type SessionRevoker interface {
+	// RevokeSessions is called with the revoked session ids or none if all sessions of sub were revoked.
+	RevokeSessions(sub string, sessionIDs []string)
+}
+
+// MemoryDenyList is an in-memory TokenDenyList and SessionRevoker.
+// Entries are kept for the maximum token lifetime, after that the tokens are expired anyway.
+type MemoryDenyList struct {
+	tokenLifetime time.Duration
+	now           func() time.Time
+
+	mu       sync.Mutex
+	sessions map[string]time.Time
+	subjects map[string]time.Time
+}
+
+// NewMemoryDenyList creates a MemoryDenyList for tokens which are valid for at most tokenLifetime.
+func NewMemoryDenyList(tokenLifetime time.Duration) *MemoryDenyList {
+	return &MemoryDenyList{
+		tokenLifetime: tokenLifetime,
+		now:           time.Now,
+		sessions:      map[string]time.Time{},
+		subjects:      map[string]time.Time{},
+	}
+}
+
+// RevokeSessions denies all tokens of the sessions or, if no session is given,
+// all tokens of sub which were issued until now.
+func (l *MemoryDenyList) RevokeSessions(sub string, sessionIDs []string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.prune()
+
+	now := l.now()
+	if len(sessionIDs) == 0 {
+		l.subjects[sub] = now
+		return
+	}
+	for _, sessionID := range sessionIDs {
+		l.sessions[sessionID] = now
+	}
+}
+
+// IsDenied implements TokenDenyList.
+func (l *MemoryDenyList) IsDenied(claims *CidaasTokenClaims) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if claims.SessionID != "" {
+		if _, ok := l.sessions[claims.SessionID]; ok {
+			return true
+		}
+	}
+
+	revokedAt, ok := l.subjects[claims.Sub]
+	return ok && claims.IssuedAt <= revokedAt.Unix()
+}
+
+// prune removes all entries older than the token lifetime and has to be called with the lock held.
+func (l *MemoryDenyList) prune() {
+	oldest := l.now().Add(-l.tokenLifetime)
+	for key, revokedAt := range l.sessions {
+		if revokedAt.Before(oldest) {
+			delete(l.sessions, key)
+		}
+	}
+	for key, revokedAt := range l.subjects {
+		if revokedAt.Before(oldest) {
+			delete(l.subjects, key)
+		}
+	}
+}
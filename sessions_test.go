@@ -0,0 +1,72 @@
+package cidaasutils
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCidaasUtils_UserSessions(t *testing.T) {
+	utils, server := mockAdminUtils(func(writer http.ResponseWriter, request *http.Request) {
+		switch request.Method + " " + request.URL.Path {
+		case "GET /session-srv/user/sub-1/sessions":
+			writer.Write([]byte(`{"success":true,"status":200,"data":[{"sid":"session-1","sub":"sub-1","userAgent":"curl","createdTime":"2021-05-01T10:00:00Z"}]}`))
+		case "POST /session-srv/user/sub-1/sessions/revoke":
+			var body revokeSessionsRequest
+			json.NewDecoder(request.Body).Decode(&body)
+			assert.Equal(t, []string{"session-1"}, body.SessionIDs)
+			writer.Write([]byte(`{"success":true,"status":200}`))
+		default:
+			t.Errorf("unexpected request %s %s", request.Method, request.URL.Path)
+		}
+	})
+	defer server.Close()
+	denyList := NewMemoryDenyList(time.Hour)
+	utils.options.DenyList = denyList
+
+	ctx := context.Background()
+	sessions, err := utils.ListUserSessions(ctx, "sub-1")
+	assert.Nil(t, err)
+	assert.Len(t, sessions, 1)
+	assert.Equal(t, "session-1", sessions[0].SessionID)
+	assert.Equal(t, "curl", sessions[0].UserAgent)
+
+	token := signTestToken(jwt.MapClaims{"iss": server.URL, "sub": "sub-1", "sid": "session-1"})
+	otherSession := signTestToken(jwt.MapClaims{"iss": server.URL, "sub": "sub-1", "sid": "session-2"})
+	_, err = utils.ValidateJWT(token)
+	assert.Nil(t, err)
+
+	assert.Nil(t, utils.RevokeUserSessions(ctx, "sub-1", "session-1"))
+
+	_, err = utils.ValidateJWT(token)
+	assert.Equal(t, TokenRevokedError, err)
+	_, err = utils.ValidateJWT(otherSession)
+	assert.Nil(t, err)
+}
+
+func TestMemoryDenyList(t *testing.T) {
+	now := time.Now()
+	denyList := NewMemoryDenyList(time.Hour)
+	denyList.now = func() time.Time { return now }
+
+	denyList.RevokeSessions("sub-1", nil)
+	assert.True(t, denyList.IsDenied(&CidaasTokenClaims{Sub: "sub-1", IssuedAt: now.Add(-time.Minute).Unix()}))
+	// tokens issued after the revocation stay valid
+	assert.False(t, denyList.IsDenied(&CidaasTokenClaims{Sub: "sub-1", IssuedAt: now.Add(time.Minute).Unix()}))
+	assert.False(t, denyList.IsDenied(&CidaasTokenClaims{Sub: "sub-2"}))
+
+	denyList.RevokeSessions("sub-2", []string{"session-1"})
+	assert.True(t, denyList.IsDenied(&CidaasTokenClaims{Sub: "sub-2", SessionID: "session-1"}))
+
+	// entries are removed after the token lifetime
+	now = now.Add(2 * time.Hour)
+	denyList.RevokeSessions("sub-3", []string{"session-3"})
+	assert.False(t, denyList.IsDenied(&CidaasTokenClaims{Sub: "sub-2", SessionID: "session-1"}))
+	assert.Len(t, denyList.sessions, 1)
+	assert.Len(t, denyList.subjects, 0)
+}
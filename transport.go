@@ -0,0 +1,225 @@
+package cidaasutils
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// MissingCallerTokenError is returned by the ForwardingTransport if the request context
+// contains no token, i.e. the request was not made inside a JWTInterceptor.
+var MissingCallerTokenError = errors.New("no caller token in request context")
+
+// ServiceTransportOptions configure the ServiceTransport.
+type ServiceTransportOptions struct {
+	// UseAdminToken sends the token of GetMyAccessToken instead of a client credentials token.
+	UseAdminToken bool
+
+	// Scopes requested with the client credentials token.
+	Scopes []string
+}
+
+// ForwardingTransportOptions configure the ForwardingTransport.
+type ForwardingTransportOptions struct {
+	// Audience enables the token exchange: the caller's token is exchanged for a token
+	// for this audience with ExchangeToken instead of being forwarded as it is.
+	Audience string
+
+	// Scopes requested with the exchanged token.
+	Scopes []string
+}
+
+// cachedToken is a token and the time from which it has to be refreshed.
+type cachedToken struct {
+	token     string
+	refreshAt time.Time
+}
+
+// minTokenCacheTime is the shortest time a token is cached, even if it expires earlier.
+// A token which expired in the meantime is replaced after the request is answered with 401.
+var minTokenCacheTime = 5 * time.Second
+
+// defaultTokenLifetime is assumed if neither expires_in nor the exp claim tell the lifetime of a token.
+var defaultTokenLifetime = time.Minute
+
+// newCachedToken caches the token until tokenExpiryLeeway before it expires.
+// Short-lived tokens are refreshed after half of their lifetime instead.
+func newCachedToken(result *AccessTokenResult) cachedToken {
+	now := time.Now()
+	lifetime := time.Duration(result.ExpiresIn) * time.Second
+	if lifetime <= 0 {
+		lifetime = defaultTokenLifetime
+		if token, _, err := new(jwt.Parser).ParseUnverified(result.AccessToken, jwt.MapClaims{}); err == nil {
+			if exp, ok := tokenExpiry(token.Claims); ok {
+				lifetime = exp.Sub(now)
+			}
+		}
+	}
+
+	cacheTime := lifetime - tokenExpiryLeeway
+	if cacheTime < lifetime/2 {
+		cacheTime = lifetime / 2
+	}
+	if cacheTime < minTokenCacheTime {
+		cacheTime = minTokenCacheTime
+	}
+	return cachedToken{token: result.AccessToken, refreshAt: now.Add(cacheTime)}
+}
+
+func (t cachedToken) valid() bool {
+	return t.token != "" && time.Now().Before(t.refreshAt)
+}
+
+// tokenTransport adds the token to all requests and retries once with a fresh token on 401.
+type tokenTransport struct {
+	next http.RoundTripper
+	// token returns the token for the request, forcing a new one if stale is not empty.
+	token func(request *http.Request, stale string) (string, error)
+}
+
+func (t *tokenTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	token, err := t.token(request, "")
+	if err != nil {
+		if request.Body != nil {
+			request.Body.Close()
+		}
+		return nil, err
+	}
+
+	resp, err := t.next.RoundTrip(withBearerToken(request, token))
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+
+	// the body of the first request was consumed, it can only be sent again if it can be recreated
+	if request.Body != nil && request.Body != http.NoBody && request.GetBody == nil {
+		return resp, nil
+	}
+
+	freshToken, err := t.token(request, token)
+	if err != nil || freshToken == token {
+		return resp, nil
+	}
+
+	retry := withBearerToken(request, freshToken)
+	if request.GetBody != nil {
+		body, err := request.GetBody()
+		if err != nil {
+			return resp, nil
+		}
+		retry.Body = body
+	}
+
+	resp.Body.Close()
+	return t.next.RoundTrip(retry)
+}
+
+// withBearerToken returns a copy of the request with the token in the Authorization header.
+func withBearerToken(request *http.Request, token string) *http.Request {
+	result := request.Clone(request.Context())
+	result.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	return result
+}
+
+// ServiceTransport returns a RoundTripper which authenticates all requests with a service token,
+// either a client credentials token or the admin token of GetMyAccessToken.
+// The token is refreshed before it expires and once more if a request is answered with 401.
+// If next is nil, http.DefaultTransport is used.
+func (u *CidaasUtils) ServiceTransport(next http.RoundTripper, options *ServiceTransportOptions) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	if options == nil {
+		options = &ServiceTransportOptions{}
+	}
+
+	var mu sync.Mutex
+	var cached cachedToken
+
+	return &tokenTransport{next: next, token: func(request *http.Request, stale string) (string, error) {
+		if options.UseAdminToken {
+			token, err := u.GetMyAccessToken()
+			if err != nil || stale == "" || token.Raw != stale {
+				return tokenRaw(token), err
+			}
+			u.resetMyAccessToken(token)
+			token, err = u.GetMyAccessToken()
+			return tokenRaw(token), err
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		if cached.valid() && cached.token != stale {
+			return cached.token, nil
+		}
+
+		result, err := u.ClientCredentialsFlow(request.Context(), options.Scopes)
+		if err != nil {
+			return "", err
+		}
+		cached = newCachedToken(result)
+		return cached.token, nil
+	}}
+}
+
+// ForwardingTransport returns a RoundTripper which authenticates all requests with the token
+// of the caller, which JWTInterceptor stored in the request context.
+// If an Audience is configured, the token is exchanged with ExchangeToken, the exchanged tokens are cached
+// until they expire and exchanged again if a request is answered with 401.
+// Forwarded tokens are sent as they are, a 401 is returned to the caller.
+// If next is nil, http.DefaultTransport is used.
+func (u *CidaasUtils) ForwardingTransport(next http.RoundTripper, options *ForwardingTransportOptions) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	if options == nil {
+		options = &ForwardingTransportOptions{}
+	}
+
+	var mu sync.Mutex
+	exchanged := map[string]cachedToken{}
+
+	return &tokenTransport{next: next, token: func(request *http.Request, stale string) (string, error) {
+		callerToken := GetAuthToken(request.Context())
+		if callerToken == "" {
+			return "", MissingCallerTokenError
+		}
+		if options.Audience == "" {
+			return callerToken, nil
+		}
+
+		mu.Lock()
+		cached, ok := exchanged[callerToken]
+		mu.Unlock()
+		if ok && cached.valid() && cached.token != stale {
+			return cached.token, nil
+		}
+
+		result, err := u.ExchangeToken(request.Context(), callerToken, options.Audience, options.Scopes, "")
+		if err != nil {
+			return "", err
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		for key, token := range exchanged {
+			if !token.valid() {
+				delete(exchanged, key)
+			}
+		}
+		exchanged[callerToken] = newCachedToken(result)
+		return result.AccessToken, nil
+	}}
+}
+
+// tokenRaw returns the raw token or an empty string if there is no token.
+func tokenRaw(token *jwt.Token) string {
+	if token == nil {
+		return ""
+	}
+	return token.Raw
+}
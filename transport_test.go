@@ -0,0 +1,202 @@
+package cidaasutils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsTokenExpired(t *testing.T) {
+	tests := []struct {
+		claims  jwt.MapClaims
+		expired bool
+	}{
+		{jwt.MapClaims{"exp": time.Now().Add(time.Hour).Unix()}, false},
+		{jwt.MapClaims{"exp": time.Now().Add(10 * time.Second).Unix()}, true},
+		{jwt.MapClaims{"exp": time.Now().Add(-time.Hour).Unix()}, true},
+		{jwt.MapClaims{}, false},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expired, IsTokenExpired(&jwt.Token{Claims: &test.claims}))
+		assert.Equal(t, test.expired, IsTokenExpired(&jwt.Token{Claims: test.claims}))
+	}
+}
+
+// mockDownstream rejects the first request with 401 and records the tokens and bodies it received.
+func mockDownstream(requests *[]string) *httptest.Server {
+	var count int32
+	return httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		body, _ := ioutil.ReadAll(request.Body)
+		*requests = append(*requests, fmt.Sprintf("%s %s", request.Header.Get("Authorization"), body))
+		if atomic.AddInt32(&count, 1) == 1 {
+			writer.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		writer.WriteHeader(http.StatusOK)
+	}))
+}
+
+func TestCidaasUtils_ServiceTransport(t *testing.T) {
+	var issued int32
+	var server *httptest.Server
+	utils, server := mockAdminUtils(func(writer http.ResponseWriter, request *http.Request) {
+		assert.Equal(t, GrantTypeClientCredentials, request.FormValue("grant_type"))
+		assert.Equal(t, "orders:write", request.FormValue("scope"))
+		count := atomic.AddInt32(&issued, 1)
+		token := signTestToken(jwt.MapClaims{"iss": server.URL, "sub": "service", "jti": fmt.Sprint(count)})
+		writer.Write([]byte(fmt.Sprintf(`{"access_token":"%s","expires_in":3600}`, token)))
+	})
+	defer server.Close()
+
+	var requests []string
+	downstream := mockDownstream(&requests)
+	defer downstream.Close()
+
+	client := &http.Client{Transport: utils.ServiceTransport(nil, &ServiceTransportOptions{Scopes: []string{"orders:write"}})}
+	for i := 0; i < 2; i++ {
+		resp, err := client.Post(downstream.URL, "text/plain", strings.NewReader("body"))
+		assert.Nil(t, err)
+		assert.Equal(t, 200, resp.StatusCode)
+	}
+
+	// the first token was rejected, the second one is reused
+	assert.Equal(t, int32(2), atomic.LoadInt32(&issued))
+	assert.Len(t, requests, 3)
+	assert.NotEqual(t, requests[0], requests[1])
+	assert.Equal(t, requests[1], requests[2])
+	assert.True(t, strings.HasSuffix(requests[1], " body"))
+}
+
+func TestNewCachedToken(t *testing.T) {
+	now := time.Now()
+	withExp := signTestToken(jwt.MapClaims{"exp": now.Add(time.Hour).Unix()})
+	expired := signTestToken(jwt.MapClaims{"exp": now.Add(-time.Hour).Unix()})
+
+	tests := []struct {
+		result    AccessTokenResult
+		cacheTime time.Duration
+	}{
+		{AccessTokenResult{AccessToken: withExp, ExpiresIn: 3600}, time.Hour - tokenExpiryLeeway},
+		// short-lived tokens are refreshed after half of their lifetime
+		{AccessTokenResult{AccessToken: withExp, ExpiresIn: 20}, 10 * time.Second},
+		{AccessTokenResult{AccessToken: withExp, ExpiresIn: 2}, minTokenCacheTime},
+		// without expires_in the exp claim is used
+		{AccessTokenResult{AccessToken: withExp, ExpiresIn: 0}, time.Hour - tokenExpiryLeeway},
+		{AccessTokenResult{AccessToken: expired, ExpiresIn: 0}, minTokenCacheTime},
+		{AccessTokenResult{AccessToken: "opaque", ExpiresIn: 0}, defaultTokenLifetime / 2},
+	}
+
+	for _, test := range tests {
+		cached := newCachedToken(&test.result)
+		assert.True(t, cached.valid())
+		assert.WithinDuration(t, now.Add(test.cacheTime), cached.refreshAt, 2*time.Second, "expires_in %d", test.result.ExpiresIn)
+	}
+}
+
+func TestCidaasUtils_ServiceTransport_NoExpiresIn(t *testing.T) {
+	var issued int32
+	var server *httptest.Server
+	utils, server := mockAdminUtils(func(writer http.ResponseWriter, request *http.Request) {
+		atomic.AddInt32(&issued, 1)
+		token := signTestToken(jwt.MapClaims{"iss": server.URL, "sub": "service", "exp": time.Now().Add(time.Hour).Unix()})
+		writer.Write([]byte(fmt.Sprintf(`{"access_token":"%s"}`, token)))
+	})
+	defer server.Close()
+
+	downstream := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {}))
+	defer downstream.Close()
+
+	// the token is cached by its exp claim instead of being fetched for every request
+	client := &http.Client{Transport: utils.ServiceTransport(nil, nil)}
+	for i := 0; i < 2; i++ {
+		_, err := client.Get(downstream.URL)
+		assert.Nil(t, err)
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&issued))
+}
+
+func TestCidaasUtils_ServiceTransport_AdminToken(t *testing.T) {
+	utils, server := mockAdminUtils(func(writer http.ResponseWriter, request *http.Request) {
+		t.Errorf("unexpected request %s", request.URL.Path)
+	})
+	defer server.Close()
+
+	var requests []string
+	downstream := mockDownstream(&requests)
+	defer downstream.Close()
+
+	client := &http.Client{Transport: utils.ServiceTransport(nil, &ServiceTransportOptions{UseAdminToken: true})}
+	resp, err := client.Get(downstream.URL)
+	assert.Nil(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+
+	token, _ := utils.GetMyAccessToken()
+	assert.Len(t, requests, 2)
+	assert.NotEqual(t, requests[0], requests[1])
+	assert.Equal(t, fmt.Sprintf("Bearer %s ", token.Raw), requests[1])
+}
+
+func TestCidaasUtils_ForwardingTransport(t *testing.T) {
+	utils := mockUtils()
+
+	var requests []string
+	downstream := mockDownstream(&requests)
+	defer downstream.Close()
+
+	client := &http.Client{Transport: utils.ForwardingTransport(nil, nil)}
+
+	request, _ := http.NewRequest("GET", downstream.URL, nil)
+	_, err := client.Do(request)
+	assert.True(t, errors.Is(err, MissingCallerTokenError))
+
+	ctx := setAuthContext(context.Background(), &CidaasTokenClaims{Sub: "test"}, "caller-token")
+	request, _ = http.NewRequestWithContext(ctx, "GET", downstream.URL, nil)
+	resp, err := client.Do(request)
+	assert.Nil(t, err)
+	// the caller's token can not be refreshed
+	assert.Equal(t, 401, resp.StatusCode)
+	assert.Equal(t, []string{"Bearer caller-token "}, requests)
+}
+
+func TestCidaasUtils_ForwardingTransport_Exchange(t *testing.T) {
+	var exchanged int32
+	var server *httptest.Server
+	utils, server := mockAdminUtils(func(writer http.ResponseWriter, request *http.Request) {
+		assert.Equal(t, "caller-token", request.FormValue("subject_token"))
+		assert.Equal(t, "orders", request.FormValue("audience"))
+		count := atomic.AddInt32(&exchanged, 1)
+		token := signTestToken(jwt.MapClaims{"iss": server.URL, "sub": "test", "jti": fmt.Sprint(count)})
+		writer.Write([]byte(fmt.Sprintf(`{"access_token":"%s","expires_in":300}`, token)))
+	})
+	defer server.Close()
+
+	var requests []string
+	downstream := mockDownstream(&requests)
+	defer downstream.Close()
+
+	client := &http.Client{Transport: utils.ForwardingTransport(nil, &ForwardingTransportOptions{Audience: "orders"})}
+	ctx := setAuthContext(context.Background(), &CidaasTokenClaims{Sub: "test"}, "caller-token")
+	for i := 0; i < 2; i++ {
+		request, _ := http.NewRequestWithContext(ctx, "GET", downstream.URL, nil)
+		resp, err := client.Do(request)
+		assert.Nil(t, err)
+		assert.Equal(t, 200, resp.StatusCode)
+	}
+
+	assert.Equal(t, int32(2), atomic.LoadInt32(&exchanged))
+	assert.Len(t, requests, 3)
+	assert.NotContains(t, requests[0], "caller-token")
+	assert.NotEqual(t, requests[0], requests[1])
+	assert.Equal(t, requests[1], requests[2])
+}
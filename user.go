@@ -1,18 +1,140 @@
 package cidaasutils
 
-import "strings"
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Timestamp is a point in time sent by Cidaas either as RFC 3339 string, as string without zone or as unix milliseconds.
+// Values which cannot be parsed leave Time zero and are kept in Raw, so they are sent back unchanged.
+type Timestamp struct {
+	time.Time
+	// Raw is the value sent by Cidaas if it could not be parsed.
+	Raw json.RawMessage
+}
+
+// timestampLayouts are tried in order, times without zone are taken as UTC.
+var timestampLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04:05Z07:00",
+}
+
+func (t *Timestamp) UnmarshalJSON(data []byte) error {
+	t.Time = time.Time{}
+	t.Raw = nil
+	if string(data) == "null" {
+		return nil
+	}
+
+	var millis int64
+	if err := json.Unmarshal(data, &millis); err == nil {
+		t.Time = time.Unix(0, millis*int64(time.Millisecond)).UTC()
+		return nil
+	}
+
+	var fractionalMillis float64
+	if err := json.Unmarshal(data, &fractionalMillis); err == nil {
+		whole := int64(fractionalMillis)
+		fraction := int64((fractionalMillis - float64(whole)) * float64(time.Millisecond))
+		t.Time = time.Unix(0, whole*int64(time.Millisecond)+fraction).UTC()
+		return nil
+	}
+
+	var value string
+	if err := json.Unmarshal(data, &value); err == nil {
+		if value == "" {
+			return nil
+		}
+		for _, layout := range timestampLayouts {
+			if parsed, err := time.Parse(layout, value); err == nil {
+				t.Time = parsed
+				return nil
+			}
+		}
+	}
+
+	t.Raw = append(json.RawMessage{}, data...)
+	return nil
+}
+
+func (t Timestamp) MarshalJSON() ([]byte, error) {
+	if t.IsZero() && len(t.Raw) > 0 {
+		return t.Raw, nil
+	}
+	if t.IsZero() {
+		return []byte("null"), nil
+	}
+	return json.Marshal(t.Format(time.RFC3339Nano))
+}
 
 type UserAccount struct {
+	ID               string     `json:"_id,omitempty"`
+	Sub              string     `json:"sub,omitempty"`
+	UserStatus       UserStatus `json:"userStatus,omitempty"`
+	UserStatusReason string     `json:"user_status_reason,omitempty"`
+	AccountType      string     `json:"accountType,omitempty"`
+	CreatedTime      Timestamp  `json:"createdTime"`
+	UpdatedTime      Timestamp  `json:"updatedTime"`
+	LastLoggedInTime Timestamp  `json:"lastLoggedInTime"`
+	LastUsedIdentity string     `json:"lastUsedIdentity,omitempty"`
+	MFAEnabled       bool       `json:"mfa_enabled"`
+	// Extra contains all fields Cidaas sent which are not known to this struct.
+	Extra map[string]json.RawMessage `json:"-"`
+}
+
+func (a *UserAccount) UnmarshalJSON(data []byte) error {
+	type plain UserAccount
+	if err := json.Unmarshal(data, (*plain)(a)); err != nil {
+		return err
+	}
+	extra, err := extraFields(data, a)
+	a.Extra = extra
+	return err
+}
+
+func (a UserAccount) MarshalJSON() ([]byte, error) {
+	type plain UserAccount
+	return marshalWithExtra(plain(a), a.Extra)
 }
 
 type UserIdentity struct {
-	Sub          string `json:"sub"`
-	Email        string `json:"email"`
-	FamilyName   string `json:"family_name"`
-	GivenName    string `json:"given_name"`
-	MobileNumber string `json:"mobile_number"`
-	Locale       string `json:"locale"`
-	Provider     string `json:"provider"`
+	ID                   string    `json:"_id,omitempty"`
+	Sub                  string    `json:"sub"`
+	Email                string    `json:"email"`
+	EmailVerified        bool      `json:"email_verified"`
+	FamilyName           string    `json:"family_name"`
+	GivenName            string    `json:"given_name"`
+	Username             string    `json:"username,omitempty"`
+	MobileNumber         string    `json:"mobile_number"`
+	MobileNumberVerified bool      `json:"mobile_number_verified"`
+	Locale               string    `json:"locale"`
+	Provider             string    `json:"provider"`
+	CreatedTime          Timestamp `json:"createdTime"`
+	UpdatedTime          Timestamp `json:"updatedTime"`
+	// Extra contains all fields Cidaas sent which are not known to this struct.
+	Extra map[string]json.RawMessage `json:"-"`
+}
+
+func (i *UserIdentity) UnmarshalJSON(data []byte) error {
+	type plain UserIdentity
+	if err := json.Unmarshal(data, (*plain)(i)); err != nil {
+		return err
+	}
+	extra, err := extraFields(data, i)
+	i.Extra = extra
+	return err
+}
+
+func (i UserIdentity) MarshalJSON() ([]byte, error) {
+	type plain UserIdentity
+	return marshalWithExtra(plain(i), i.Extra)
 }
 
 type CustomField struct {
@@ -20,24 +142,165 @@ type CustomField struct {
 }
 
 type UserInfo struct {
-	Identity     UserIdentity           `json:"identity"`
+	// Identity is the primary identity of the user.
+	Identity UserIdentity `json:"identity"`
+	// Identities contains all identities linked to the user, e.g. social logins.
+	Identities   []UserIdentity         `json:"identities,omitempty"`
 	UserAccount  UserAccount            `json:"userAccount"`
 	Roles        []string               `json:"roles"`
 	CustomFields map[string]CustomField `json:"customFields"`
+	// Extra contains all fields Cidaas sent which are not known to this struct.
+	Extra map[string]json.RawMessage `json:"-"`
+}
+
+func (i *UserInfo) UnmarshalJSON(data []byte) error {
+	type plain UserInfo
+	if err := json.Unmarshal(data, (*plain)(i)); err != nil {
+		return err
+	}
+	extra, err := extraFields(data, i)
+	i.Extra = extra
+	return err
+}
+
+func (i UserInfo) MarshalJSON() ([]byte, error) {
+	type plain UserInfo
+	return marshalWithExtra(plain(i), i.Extra)
 }
 
 type UserInfoResponse struct {
 	Data UserInfo `json:"data"`
 }
 
+// UserUpdateRequest describes changes to a user profile.
+// Nil fields are not sent and stay unchanged. Custom fields with a nil value are removed.
 type UserUpdateRequest struct {
-	Email        *string                 `json:"email"`
-	FamilyName   *string                 `json:"family_name"`
-	GivenName    *string                 `json:"given_name"`
-	MobileNumber *string                 `json:"mobile_number"`
-	Provider     *string                 `json:"provider"`
-	Locale       *string                 `json:"locale"`
-	CustomFields *map[string]CustomField `json:"customFields"`
+	Email        *string                 `json:"email,omitempty"`
+	FamilyName   *string                 `json:"family_name,omitempty"`
+	GivenName    *string                 `json:"given_name,omitempty"`
+	MobileNumber *string                 `json:"mobile_number,omitempty"`
+	Provider     *string                 `json:"provider,omitempty"`
+	Locale       *string                 `json:"locale,omitempty"`
+	CustomFields *map[string]CustomField `json:"customFields,omitempty"`
+}
+
+// UserCreateRequest describes a new user.
+// Either Password has to be set or InviteUser, in which case Cidaas sends an invitation to the user.
+type UserCreateRequest struct {
+	Email                string                 `json:"email,omitempty"`
+	EmailVerified        bool                   `json:"email_verified,omitempty"`
+	MobileNumber         string                 `json:"mobile_number,omitempty"`
+	MobileNumberVerified bool                   `json:"mobile_number_verified,omitempty"`
+	Username             string                 `json:"username,omitempty"`
+	GivenName            string                 `json:"given_name,omitempty"`
+	FamilyName           string                 `json:"family_name,omitempty"`
+	Locale               string                 `json:"locale,omitempty"`
+	Provider             string                 `json:"provider,omitempty"`
+	Password             string                 `json:"password,omitempty"`
+	PasswordEcho         string                 `json:"password_echo,omitempty"`
+	InviteUser           bool                   `json:"invite_user,omitempty"`
+	Roles                []string               `json:"roles,omitempty"`
+	CustomFields         map[string]CustomField `json:"customFields,omitempty"`
+}
+
+type UserCreateResponse struct {
+	Data struct {
+		Sub string `json:"sub"`
+	} `json:"data"`
+}
+
+// UserValidationError is returned if a user could not be created because a field is invalid.
+type UserValidationError struct {
+	// Field is the json name of the invalid field, e.g. "email".
+	Field string
+	// Reason is one of the UserValidationReason constants.
+	Reason string
+	// Err is the CidaasError if the validation failed on the Cidaas side.
+	Err error
+}
+
+const (
+	UserValidationReasonRequired  = "required"
+	UserValidationReasonInvalid   = "invalid"
+	UserValidationReasonDuplicate = "duplicate"
+)
+
+func (e *UserValidationError) Error() string {
+	return fmt.Sprintf("user validation failed: %s is %s", e.Field, e.Reason)
+}
+
+func (e *UserValidationError) Unwrap() error {
+	return e.Err
+}
+
+func isUserValidationError(err error, field string, reason string) bool {
+	var validationError *UserValidationError
+	return errors.As(err, &validationError) && validationError.Field == field && validationError.Reason == reason
+}
+
+// IsDuplicateEmail reports whether a user with the same email already exists.
+func IsDuplicateEmail(err error) bool {
+	return isUserValidationError(err, "email", UserValidationReasonDuplicate)
+}
+
+// IsDuplicateMobileNumber reports whether a user with the same mobile number already exists.
+func IsDuplicateMobileNumber(err error) bool {
+	return isUserValidationError(err, "mobile_number", UserValidationReasonDuplicate)
+}
+
+// validate checks the request before sending it to Cidaas.
+func (r *UserCreateRequest) validate() error {
+	if r.Email == "" && r.MobileNumber == "" && r.Username == "" {
+		return &UserValidationError{Field: "email", Reason: UserValidationReasonRequired}
+	}
+	if r.Email != "" && !strings.Contains(r.Email, "@") {
+		return &UserValidationError{Field: "email", Reason: UserValidationReasonInvalid}
+	}
+	if r.MobileNumber != "" && !strings.HasPrefix(r.MobileNumber, "+") {
+		return &UserValidationError{Field: "mobile_number", Reason: UserValidationReasonInvalid}
+	}
+	if r.Password == "" && !r.InviteUser {
+		return &UserValidationError{Field: "password", Reason: UserValidationReasonRequired}
+	}
+	if r.Password != "" && r.InviteUser {
+		return &UserValidationError{Field: "invite_user", Reason: UserValidationReasonInvalid}
+	}
+	if r.PasswordEcho != "" && r.PasswordEcho != r.Password {
+		return &UserValidationError{Field: "password_echo", Reason: UserValidationReasonInvalid}
+	}
+	return nil
+}
+
+// toUserValidationError converts Cidaas errors about already existing identities.
+func toUserValidationError(err error) error {
+	cidaasError := AsCidaasError(err)
+	if cidaasError == nil {
+		return err
+	}
+
+	description := strings.ToLower(cidaasError.Description())
+	duplicate := cidaasError.StatusCode == http.StatusConflict ||
+		strings.Contains(description, "already exist") || strings.Contains(description, "already registered")
+	if !duplicate {
+		return err
+	}
+
+	field := "email"
+	if strings.Contains(description, "mobile") || strings.Contains(description, "phone") {
+		field = "mobile_number"
+	}
+	return &UserValidationError{Field: field, Reason: UserValidationReasonDuplicate, Err: err}
+}
+
+type UserIdentitiesResponse struct {
+	Success bool           `json:"success"`
+	Status  int            `json:"status"`
+	Data    []UserIdentity `json:"data"`
+}
+
+type linkIdentityRequest struct {
+	MasterSub string `json:"master_sub"`
+	SubToLink string `json:"sub_to_link"`
 }
 
 type SimpleStatusResponse struct {
@@ -48,6 +311,19 @@ type SimpleStatusResponse struct {
 
 // GetUserProfileInternally returns the internal user profile for the given sub id.
 func (u *CidaasUtils) GetUserProfileInternally(sub string) (*UserInfo, error) {
+	return u.getUserProfile(context.Background(), sub)
+}
+
+// getUserProfile returns the profile through the cache if one is configured.
+func (u *CidaasUtils) getUserProfile(ctx context.Context, sub string) (*UserInfo, error) {
+	if cache := u.profileCache(); cache != nil {
+		return u.cachedUserProfile(ctx, cache, sub)
+	}
+	return u.fetchUserProfile(ctx, sub)
+}
+
+// fetchUserProfile always asks Cidaas, e.g. before changing the profile.
+func (u *CidaasUtils) fetchUserProfile(ctx context.Context, sub string) (*UserInfo, error) {
 	token, err := u.GetMyAccessToken()
 	if err != nil {
 		return nil, err
@@ -56,7 +332,7 @@ func (u *CidaasUtils) GetUserProfileInternally(sub string) (*UserInfo, error) {
 	path := strings.Replace(userinfoInternalEndpoint, "{sub}", sub, 1)
 
 	var result UserInfoResponse
-	err = u.doRequest(&RequestInit{Path: path, Token: token.Raw}, &result)
+	err = u.doRequest(&RequestInit{Operation: "GetUserProfileInternally", Path: path, Token: token.Raw, Context: ctx}, &result)
 	if err != nil {
 		return nil, err
 	}
@@ -66,6 +342,10 @@ func (u *CidaasUtils) GetUserProfileInternally(sub string) (*UserInfo, error) {
 
 // UpdateUserProfileInternally updates the user's profile.
 func (u *CidaasUtils) UpdateUserProfileInternally(sub string, info *UserUpdateRequest) error {
+	return u.updateUserProfile(context.Background(), sub, info)
+}
+
+func (u *CidaasUtils) updateUserProfile(ctx context.Context, sub string, info *UserUpdateRequest) error {
 	token, err := u.GetMyAccessToken()
 	if err != nil {
 		return err
@@ -74,10 +354,101 @@ func (u *CidaasUtils) UpdateUserProfileInternally(sub string, info *UserUpdateRe
 	path := strings.Replace(userUpdateEndpoint, "{sub}", sub, 1)
 
 	var result SimpleStatusResponse
-	err = u.doRequest(&RequestInit{Path: path, Token: token.Raw, Method: "PUT", BodyJSON: *info}, &result)
+	err = u.doRequest(&RequestInit{Operation: "UpdateUserProfileInternally", Path: path, Token: token.Raw, Method: "PUT", BodyJSON: *info, Context: ctx}, &result)
+	u.invalidateUserProfiles(ctx, sub)
 	if err != nil {
 		return err
 	}
 
 	return nil
 }
+
+// CreateUser creates a new user and returns its sub.
+// Invalid requests and already existing email addresses or mobile numbers result in a *UserValidationError.
+func (u *CidaasUtils) CreateUser(ctx context.Context, request *UserCreateRequest) (string, error) {
+	if err := request.validate(); err != nil {
+		return "", err
+	}
+
+	token, err := u.GetMyAccessToken()
+	if err != nil {
+		return "", err
+	}
+
+	body := *request
+	if body.Provider == "" {
+		body.Provider = "self"
+	}
+	if body.PasswordEcho == "" {
+		body.PasswordEcho = body.Password
+	}
+
+	var result UserCreateResponse
+	err = u.doRequest(&RequestInit{Operation: "CreateUser", Path: userCreateEndpoint, Token: token.Raw, Method: "POST", BodyJSON: body, Context: ctx}, &result)
+	if err != nil {
+		return "", toUserValidationError(err)
+	}
+
+	return result.Data.Sub, nil
+}
+
+// ListUserIdentities returns all identities linked to the user, e.g. the self registered one and social logins.
+func (u *CidaasUtils) ListUserIdentities(ctx context.Context, sub string) ([]UserIdentity, error) {
+	token, err := u.GetMyAccessToken()
+	if err != nil {
+		return nil, err
+	}
+
+	path := strings.Replace(userIdentitiesEndpoint, "{sub}", sub, 1)
+
+	var result UserIdentitiesResponse
+	err = u.doRequest(&RequestInit{Operation: "ListUserIdentities", Path: path, Token: token.Raw, Context: ctx}, &result)
+	if errors.Is(err, NoResultError) {
+		return []UserIdentity{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return result.Data, nil
+}
+
+// LinkIdentity merges the user subToLink into the user masterSub.
+// Afterwards all identities of subToLink belong to masterSub.
+func (u *CidaasUtils) LinkIdentity(ctx context.Context, masterSub string, subToLink string) error {
+	body := linkIdentityRequest{MasterSub: masterSub, SubToLink: subToLink}
+	defer u.invalidateUserProfiles(ctx, masterSub, subToLink)
+	return u.doAdminRequest(&RequestInit{Operation: "LinkIdentity", Path: linkIdentityEndpoint, Method: "POST", BodyJSON: body, Context: ctx})
+}
+
+// UnlinkIdentity removes the identity from the user.
+func (u *CidaasUtils) UnlinkIdentity(ctx context.Context, sub string, identityID string) error {
+	path := strings.Replace(userIdentityEndpoint, "{sub}", sub, 1)
+	path = strings.Replace(path, "{identity}", identityID, 1)
+	defer u.invalidateUserProfiles(ctx, sub)
+	return u.doAdminRequest(&RequestInit{Operation: "UnlinkIdentity", Path: path, Method: "DELETE", Context: ctx})
+}
+
+// SetPrimaryIdentity makes the identity the primary identity of the user,
+// which is returned as UserInfo.Identity.
+func (u *CidaasUtils) SetPrimaryIdentity(ctx context.Context, sub string, identityID string) error {
+	path := strings.Replace(userPrimaryIdentityEndpoint, "{sub}", sub, 1)
+	path = strings.Replace(path, "{identity}", identityID, 1)
+	defer u.invalidateUserProfiles(ctx, sub)
+	return u.doAdminRequest(&RequestInit{Operation: "SetPrimaryIdentity", Path: path, Method: "PUT", Context: ctx})
+}
+
+// doAdminRequest sends the request with the admin token and only checks whether it was successful.
+func (u *CidaasUtils) doAdminRequest(init *RequestInit) error {
+	token, err := u.GetMyAccessToken()
+	if err != nil {
+		return err
+	}
+	init.Token = token.Raw
+
+	var result SimpleStatusResponse
+	err = u.doRequest(init, &result)
+	if errors.Is(err, NoResultError) {
+		return nil
+	}
+	return err
+}
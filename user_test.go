@@ -1,9 +1,16 @@
 package cidaasutils
 
 import (
-	"github.com/stretchr/testify/assert"
+	"context"
+	"encoding/json"
+	"errors"
 	"log"
+	"net/http"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
 )
 
 func TestCidaasUtils_GetUserProfileInternally(t *testing.T) {
@@ -23,3 +30,161 @@ func TestCidaasUtils_UpdateUserProfileInternally(t *testing.T) {
 		&UserUpdateRequest{Provider: &provider})
 	assert.Nil(t, err)
 }
+
+func TestCidaasUtils_CreateUser(t *testing.T) {
+	utils, server := mockAdminUtils(func(writer http.ResponseWriter, request *http.Request) {
+		assert.Equal(t, "POST", request.Method)
+		assert.Equal(t, "/"+userCreateEndpoint, request.URL.Path)
+		assert.True(t, strings.HasPrefix(request.Header.Get("Authorization"), "Bearer "))
+
+		var body UserCreateRequest
+		json.NewDecoder(request.Body).Decode(&body)
+		assert.Equal(t, "new@example.com", body.Email)
+		assert.Equal(t, "self", body.Provider)
+		assert.Equal(t, "secret", body.PasswordEcho)
+		assert.Equal(t, []string{"USER"}, body.Roles)
+		assert.Equal(t, 15.0, body.CustomFields["customer_id"].Value)
+
+		writer.Write([]byte(`{"success":true,"status":200,"data":{"sub":"new-sub"}}`))
+	})
+	defer server.Close()
+
+	sub, err := utils.CreateUser(context.Background(), &UserCreateRequest{
+		Email:        "new@example.com",
+		Password:     "secret",
+		Roles:        []string{"USER"},
+		CustomFields: map[string]CustomField{"customer_id": {Value: 15}},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "new-sub", sub)
+}
+
+func TestCidaasUtils_CreateUser_Validation(t *testing.T) {
+	utils, server := mockAdminUtils(func(writer http.ResponseWriter, request *http.Request) {
+		writer.WriteHeader(http.StatusConflict)
+		writer.Write([]byte(`{"success":false,"status":409,"error":{"code":"USER_EXISTS","error":"mobile number already exists"}}`))
+	})
+	defer server.Close()
+
+	_, err := utils.CreateUser(context.Background(), &UserCreateRequest{Email: "new@example.com"})
+	var validationError *UserValidationError
+	assert.True(t, errors.As(err, &validationError))
+	assert.Equal(t, "password", validationError.Field)
+	assert.Equal(t, UserValidationReasonRequired, validationError.Reason)
+
+	_, err = utils.CreateUser(context.Background(), &UserCreateRequest{MobileNumber: "+4912345", InviteUser: true})
+	assert.True(t, IsDuplicateMobileNumber(err))
+	assert.False(t, IsDuplicateEmail(err))
+	assert.Equal(t, 409, AsCidaasError(err).StatusCode)
+}
+
+func TestUserInfo_Unmarshal(t *testing.T) {
+	var response UserInfoResponse
+	err := json.Unmarshal([]byte(`{"success":true,"data":{
+		"identity":{"_id":"id-1","sub":"sub-1","email":"test@example.com","email_verified":true,"provider":"self","birthdate":"1990-01-01"},
+		"identities":[
+			{"_id":"id-1","sub":"sub-1","provider":"self"},
+			{"_id":"id-2","sub":"sub-1","provider":"google"}
+		],
+		"userAccount":{"userStatus":"ACTIVE","createdTime":"2021-05-01T10:00:00Z","lastLoggedInTime":1620000000000,"mfa_enabled":true,"identityCount":2},
+		"roles":["USER"],
+		"consents":["terms"]
+	}}`), &response)
+	assert.Nil(t, err)
+
+	info := response.Data
+	assert.Equal(t, "id-1", info.Identity.ID)
+	assert.True(t, info.Identity.EmailVerified)
+	assert.False(t, info.Identity.MobileNumberVerified)
+	assert.Equal(t, json.RawMessage(`"1990-01-01"`), info.Identity.Extra["birthdate"])
+	assert.Len(t, info.Identities, 2)
+	assert.Equal(t, "google", info.Identities[1].Provider)
+
+	assert.Equal(t, UserStatusActive, info.UserAccount.UserStatus)
+	assert.Equal(t, time.Date(2021, 5, 1, 10, 0, 0, 0, time.UTC), info.UserAccount.CreatedTime.Time)
+	assert.Equal(t, time.Unix(1620000000, 0).UTC(), info.UserAccount.LastLoggedInTime.Time)
+	assert.True(t, info.UserAccount.UpdatedTime.IsZero())
+	assert.True(t, info.UserAccount.MFAEnabled)
+	assert.Equal(t, json.RawMessage(`2`), info.UserAccount.Extra["identityCount"])
+	assert.Equal(t, json.RawMessage(`["terms"]`), info.Extra["consents"])
+
+	// extra fields are kept when marshalling again
+	data, err := json.Marshal(info)
+	assert.Nil(t, err)
+	var again UserInfo
+	assert.Nil(t, json.Unmarshal(data, &again))
+	assert.Equal(t, info.Extra, again.Extra)
+	assert.Equal(t, info.UserAccount.Extra, again.UserAccount.Extra)
+	assert.Equal(t, info.UserAccount.CreatedTime.Unix(), again.UserAccount.CreatedTime.Unix())
+}
+
+func TestTimestamp_Unmarshal(t *testing.T) {
+	expected := time.Date(2021, 5, 1, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		data     string
+		expected time.Time
+	}{
+		{`"2021-05-01T10:00:00Z"`, expected},
+		{`"2021-05-01T10:00:00"`, expected},
+		{`"2021-05-01 10:00:00"`, expected},
+		{`"2021-05-01T10:00:00.000"`, expected},
+		{`1619863200000`, expected},
+		{`1619863200000.5`, expected.Add(500 * time.Microsecond)},
+		{`null`, time.Time{}},
+		{`""`, time.Time{}},
+	}
+
+	for _, test := range tests {
+		var timestamp Timestamp
+		assert.Nil(t, json.Unmarshal([]byte(test.data), &timestamp), test.data)
+		assert.True(t, test.expected.Equal(timestamp.Time), test.data)
+		assert.Nil(t, timestamp.Raw, test.data)
+	}
+
+	// unknown formats do not fail the whole response and are sent back unchanged
+	var account UserAccount
+	assert.Nil(t, json.Unmarshal([]byte(`{"createdTime":"01.05.2021","updatedTime":{"$date":1}}`), &account))
+	assert.True(t, account.CreatedTime.IsZero())
+	assert.Equal(t, json.RawMessage(`"01.05.2021"`), account.CreatedTime.Raw)
+	assert.Equal(t, json.RawMessage(`{"$date":1}`), account.UpdatedTime.Raw)
+
+	data, err := json.Marshal(account.CreatedTime)
+	assert.Nil(t, err)
+	assert.Equal(t, `"01.05.2021"`, string(data))
+}
+
+func TestCidaasUtils_Identities(t *testing.T) {
+	var requests []string
+	utils, server := mockAdminUtils(func(writer http.ResponseWriter, request *http.Request) {
+		requests = append(requests, request.Method+" "+request.URL.Path)
+		switch request.Method + " " + request.URL.Path {
+		case "GET /users-srv/user/sub-1/identities":
+			writer.Write([]byte(`{"success":true,"status":200,"data":[{"_id":"id-1","provider":"self"},{"_id":"id-2","provider":"google"}]}`))
+		case "POST /" + linkIdentityEndpoint:
+			var body linkIdentityRequest
+			json.NewDecoder(request.Body).Decode(&body)
+			assert.Equal(t, linkIdentityRequest{MasterSub: "sub-1", SubToLink: "sub-2"}, body)
+			writer.Write([]byte(`{"success":true,"status":200}`))
+		default:
+			writer.Write([]byte(`{"success":true,"status":200}`))
+		}
+	})
+	defer server.Close()
+
+	ctx := context.Background()
+	identities, err := utils.ListUserIdentities(ctx, "sub-1")
+	assert.Nil(t, err)
+	assert.Len(t, identities, 2)
+	assert.Equal(t, "google", identities[1].Provider)
+
+	assert.Nil(t, utils.LinkIdentity(ctx, "sub-1", "sub-2"))
+	assert.Nil(t, utils.SetPrimaryIdentity(ctx, "sub-1", "id-2"))
+	assert.Nil(t, utils.UnlinkIdentity(ctx, "sub-1", "id-1"))
+
+	assert.Equal(t, []string{
+		"GET /users-srv/user/sub-1/identities",
+		"POST /users-srv/user/linkaccount",
+		"PUT /users-srv/user/sub-1/identities/id-2/primary",
+		"DELETE /users-srv/user/sub-1/identities/id-1",
+	}, requests)
+}
@@ -1,5 +1,11 @@
 package cidaasutils
 
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+)
+
 func includesStrings(input []string, search []string) bool {
 	for _, s := range search {
 		if !includesString(input, s) {
@@ -17,3 +23,59 @@ func includesString(input []string, search string) bool {
 	}
 	return false
 }
+
+// jsonFieldNames returns the json names of all fields of the struct type t.
+func jsonFieldNames(t reflect.Type) []string {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	var result []string
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		result = append(result, name)
+	}
+	return result
+}
+
+// extraFields returns all fields of the json object data which are not fields of v.
+func extraFields(data []byte, v interface{}) (map[string]json.RawMessage, error) {
+	var all map[string]json.RawMessage
+	if err := json.Unmarshal(data, &all); err != nil {
+		return nil, err
+	}
+
+	for _, name := range jsonFieldNames(reflect.TypeOf(v)) {
+		delete(all, name)
+	}
+	if len(all) == 0 {
+		return nil, nil
+	}
+	return all, nil
+}
+
+// marshalWithExtra marshals v and adds the extra fields which v does not contain itself.
+func marshalWithExtra(v interface{}, extra map[string]json.RawMessage) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil || len(extra) == 0 {
+		return data, err
+	}
+
+	var all map[string]json.RawMessage
+	if err := json.Unmarshal(data, &all); err != nil {
+		return nil, err
+	}
+	for key, value := range extra {
+		if _, ok := all[key]; !ok {
+			all[key] = value
+		}
+	}
+	return json.Marshal(all)
+}
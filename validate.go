@@ -7,20 +7,31 @@ import (
 	"net/http"
 	"strings"
 
+	"github.com/MicahParks/keyfunc"
 	"github.com/mitchellh/mapstructure"
 )
 
 // TokenInvalidError is returned if the given token is invalid
 var TokenInvalidError = errors.New("token is invalid")
 
+// TokenRevokedError is returned if the given token is valid but rejected by the configured TokenDenyList
+var TokenRevokedError = errors.New("token is revoked")
+
 // CidaasClaimKey Key used for storing the claims on the context
 var CidaasClaimKey = "CIDAAS_CLAIMS"
 
+// CidaasTokenKey Key used for storing the raw token on the context
+var CidaasTokenKey = "CIDAAS_TOKEN"
+
 // ValidateJWT validates the given jwt and returns the parsed token.
 func (u *CidaasUtils) ValidateJWT(jwtToken string) (*jwt.Token, error) {
-	token, err := jwt.ParseWithClaims(jwtToken, &jwt.MapClaims{}, u.jwks.KeyFunc)
+	token, err := jwt.ParseWithClaims(jwtToken, &jwt.MapClaims{}, u.keyFunc)
 	if err != nil {
-		if _, ok := err.(*jwt.ValidationError); ok {
+		if validationError, ok := err.(*jwt.ValidationError); ok {
+			// the key might be unknown only because the JWKs could not be refreshed
+			if errors.Is(validationError.Inner, keyfunc.ErrKIDNotFound) && u.CircuitState(EndpointGroupJWKS) == CircuitOpen {
+				return nil, CircuitOpenError
+			}
 			return nil, TokenInvalidError
 		}
 		return nil, err
@@ -30,6 +41,17 @@ func (u *CidaasUtils) ValidateJWT(jwtToken string) (*jwt.Token, error) {
 	if !token.Claims.(*jwt.MapClaims).VerifyIssuer(u.options.BaseURL, true) {
 		return nil, TokenInvalidError
 	}
+
+	// Check if token was revoked
+	if u.options.DenyList != nil {
+		claims, err := toCidaasTokenClaims(token.Claims)
+		if err != nil {
+			return nil, TokenInvalidError
+		}
+		if u.options.DenyList.IsDenied(claims) {
+			return nil, TokenRevokedError
+		}
+	}
 	return token, nil
 }
 
@@ -40,11 +62,18 @@ func (u *CidaasUtils) ToCidaasTokenClaims(jwtToken *jwt.Token) (*CidaasTokenClai
 
 // CidaasTokenClaims describe the claims on a given token
 type CidaasTokenClaims struct {
-	Sub       string   `json:"sub,omitempty"`
-	Email     string   `json:"email,omitempty"`
-	Scopes    []string `json:"scopes,omitempty"`
-	Roles     []string `json:"roles,omitempty"`
-	ExpiresAt int64    `json:"exp,omitempty"`
+	Sub                 string            `json:"sub,omitempty"`
+	Email               string            `json:"email,omitempty"`
+	SessionID           string            `json:"sid,omitempty" mapstructure:"sid"`
+	EmailVerified       bool              `json:"email_verified,omitempty" mapstructure:"email_verified"`
+	PhoneNumberVerified bool              `json:"phone_number_verified,omitempty" mapstructure:"phone_number_verified"`
+	Scopes              []string          `json:"scopes,omitempty"`
+	Roles               []string          `json:"roles,omitempty"`
+	Groups              []GroupMembership `json:"groups,omitempty"`
+	IssuedAt            int64             `json:"iat,omitempty" mapstructure:"iat"`
+	ExpiresAt           int64             `json:"exp,omitempty"`
+	// Actor is set if the token was issued by ExchangeToken with an actor token
+	Actor *TokenActor `json:"act,omitempty" mapstructure:"act"`
 	// Other contains all non-standard claims of the token
 	Other jwt.MapClaims
 }
@@ -64,6 +93,12 @@ type jwtInterceptorOptions struct {
 	RejectUnauthorized bool
 	Scopes             []string
 	Roles              []string
+	// Groups maps group ids to the group roles which are required
+	Groups              map[string][]string
+	VerifiedEmail       bool
+	VerifiedPhoneNumber bool
+	// Consents maps consent ids to the version which has to be accepted
+	Consents map[string]string
 }
 
 // WithAuthorized allows only requests which contain a valid token
@@ -87,6 +122,50 @@ func WithRoles(roles []string) JWTInterceptorOption {
 	}
 }
 
+// WithVerifiedEmail allows only requests which contain a JWT with a verified email address.
+func WithVerifiedEmail() JWTInterceptorOption {
+	return func(option *jwtInterceptorOptions) {
+		option.VerifiedEmail = true
+	}
+}
+
+// WithVerifiedPhoneNumber allows only requests which contain a JWT with a verified phone number.
+func WithVerifiedPhoneNumber() JWTInterceptorOption {
+	return func(option *jwtInterceptorOptions) {
+		option.VerifiedPhoneNumber = true
+	}
+}
+
+// WithConsent allows only requests of users who accepted the given version of the consent,
+// an empty version accepts any version. It can be used multiple times to require several consents.
+// The consents are looked up with the admin token on every request, which adds a request to Cidaas
+// to every request of the handler unless Options.ConsentCache is set.
+// Requests of users with missing consents are answered with 403 and a ConsentRequiredResponse.
+func WithConsent(consentID string, version string) JWTInterceptorOption {
+	return func(option *jwtInterceptorOptions) {
+		if option.Consents == nil {
+			option.Consents = map[string]string{}
+		}
+		option.Consents[consentID] = version
+	}
+}
+
+// WithGroup allows only requests which contain a JWT with a membership in the given group.
+func WithGroup(groupID string) JWTInterceptorOption {
+	return WithGroupRoles(groupID, []string{})
+}
+
+// WithGroupRoles allows only requests which contain a JWT with all of the provided roles in the given group.
+// It can be used multiple times to require several groups.
+func WithGroupRoles(groupID string, roles []string) JWTInterceptorOption {
+	return func(option *jwtInterceptorOptions) {
+		if option.Groups == nil {
+			option.Groups = map[string][]string{}
+		}
+		option.Groups[groupID] = roles
+	}
+}
+
 // JWTInterceptor parses and validates Bearer token in requests, compares them to the
 // given option constraints and attaches the CidaasTokenClaims to the request context.
 func (u *CidaasUtils) JWTInterceptor(next http.Handler, options ...JWTInterceptorOption) http.Handler {
@@ -105,7 +184,7 @@ func (u *CidaasUtils) jwtInterceptor(next http.Handler, option *jwtInterceptorOp
 		if (authorizationHeader == "" || !strings.HasPrefix(authorizationHeader, "Bearer ")) && option.RejectUnauthorized {
 			writer.WriteHeader(http.StatusUnauthorized)
 			return
-		} else if authorizationHeader == "" || !strings.HasPrefix(authorizationHeader, "Bearer "){
+		} else if authorizationHeader == "" || !strings.HasPrefix(authorizationHeader, "Bearer ") {
 			// nothing to parse, continue
 			next.ServeHTTP(writer, request)
 			return
@@ -114,7 +193,10 @@ func (u *CidaasUtils) jwtInterceptor(next http.Handler, option *jwtInterceptorOp
 		// parse and validate token
 		token := strings.TrimPrefix(authorizationHeader, "Bearer ")
 		parsed, err := u.ValidateJWT(token)
-		if err != nil {
+		if errors.Is(err, CircuitOpenError) {
+			writer.WriteHeader(http.StatusServiceUnavailable)
+			return
+		} else if err != nil {
 			writer.WriteHeader(http.StatusUnauthorized)
 			return
 		}
@@ -138,10 +220,38 @@ func (u *CidaasUtils) jwtInterceptor(next http.Handler, option *jwtInterceptorOp
 			return
 		}
 
+		// verify contact information
+		if (option.VerifiedEmail && !claims.EmailVerified) || (option.VerifiedPhoneNumber && !claims.PhoneNumberVerified) {
+			writer.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		// verify groups
+		for groupID, roles := range option.Groups {
+			if !hasGroupRoles(claims.Groups, groupID, roles) {
+				writer.WriteHeader(http.StatusForbidden)
+				return
+			}
+		}
+
+		// verify consents
+		missing, err := u.missingConsents(request.Context(), claims.Sub, option.Consents)
+		if errors.Is(err, CircuitOpenError) {
+			writer.WriteHeader(http.StatusServiceUnavailable)
+			return
+		} else if err != nil {
+			u.logger().Error("could not check consents", "sub", claims.Sub, "error", err)
+			writer.WriteHeader(http.StatusInternalServerError)
+			return
+		} else if len(missing) > 0 {
+			writeConsentRequired(writer, missing)
+			return
+		}
+
 		// attach to context
 		request = request.WithContext(
 			setAuthContext(
-				request.Context(), claims,
+				request.Context(), claims, token,
 			),
 		)
 
@@ -149,13 +259,22 @@ func (u *CidaasUtils) jwtInterceptor(next http.Handler, option *jwtInterceptorOp
 	}
 }
 
+// toCidaasTokenClaims converts the claims of a token. Claims with an unexpected type are left empty
+// instead of failing, so only the options which need such a claim reject the token.
 func toCidaasTokenClaims(claims jwt.Claims) (*CidaasTokenClaims, error) {
 	mapClaims := claims.(*jwt.MapClaims)
 	result := &CidaasTokenClaims{}
 
-	err := mapstructure.Decode(claims, &result)
+	err := decodeClaims(*mapClaims, result)
 	if err != nil {
-		return nil, err
+		// decode every claim on its own to skip the broken ones
+		*result = CidaasTokenClaims{}
+		for key, value := range *mapClaims {
+			claim := map[string]interface{}{key: value}
+			if decodeClaims(claim, &CidaasTokenClaims{}) == nil {
+				_ = decodeClaims(claim, result)
+			}
+		}
 	}
 
 	result.Other = *mapClaims
@@ -163,7 +282,19 @@ func toCidaasTokenClaims(claims jwt.Claims) (*CidaasTokenClaims, error) {
 	return result, nil
 }
 
-func setAuthContext(ctx context.Context, claims *CidaasTokenClaims) context.Context {
+func decodeClaims(claims map[string]interface{}, result *CidaasTokenClaims) error {
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		WeaklyTypedInput: true,
+		Result:           result,
+	})
+	if err != nil {
+		return err
+	}
+	return decoder.Decode(claims)
+}
+
+func setAuthContext(ctx context.Context, claims *CidaasTokenClaims, token string) context.Context {
+	ctx = context.WithValue(ctx, CidaasTokenKey, token)
 	return context.WithValue(ctx, CidaasClaimKey, claims)
 }
 
@@ -176,3 +307,9 @@ func GetAuthContext(ctx context.Context) *CidaasTokenClaims {
 	}
 	return result
 }
+
+// GetAuthToken returns the raw token from the request context if it exists otherwise an empty string.
+func GetAuthToken(ctx context.Context) string {
+	token, _ := ctx.Value(CidaasTokenKey).(string)
+	return token
+}
@@ -0,0 +1,119 @@
+package cidaasutils
+
+import (
+	"context"
+)
+
+// VerificationMethod is the way a user verifies an email address or mobile number.
+type VerificationMethod string
+
+const (
+	// VerificationEmailLink sends a link to the email address of the user.
+	VerificationEmailLink VerificationMethod = "email_link"
+	// VerificationEmailCode sends a code to the email address of the user.
+	VerificationEmailCode VerificationMethod = "email_code"
+	// VerificationSMSCode sends a code to the mobile number of the user.
+	VerificationSMSCode VerificationMethod = "sms_code"
+)
+
+// medium returns the verification medium and processing type used by Cidaas.
+func (m VerificationMethod) medium() (string, string) {
+	switch m {
+	case VerificationEmailLink:
+		return "email", "LINK"
+	case VerificationSMSCode:
+		return "sms", "CODE"
+	default:
+		return "email", "CODE"
+	}
+}
+
+// Verification is a started verification which is completed with VerifyCode
+// or, for VerificationEmailLink, by the user clicking the link.
+type Verification struct {
+	ID     string             `json:"accvid"`
+	Sub    string             `json:"-"`
+	Method VerificationMethod `json:"-"`
+}
+
+// VerificationStatus tells which contact information of a user is verified.
+type VerificationStatus struct {
+	Email                string
+	EmailVerified        bool
+	MobileNumber         string
+	MobileNumberVerified bool
+}
+
+type verificationInitiateRequest struct {
+	Sub                string `json:"sub"`
+	VerificationMedium string `json:"verificationMedium"`
+	ProcessingType     string `json:"processingType"`
+	ClientID           string `json:"client_id"`
+}
+
+type verificationInitiateResponse struct {
+	Success bool         `json:"success"`
+	Status  int          `json:"status"`
+	Data    Verification `json:"data"`
+}
+
+type verificationVerifyRequest struct {
+	ID   string `json:"accvid"`
+	Code string `json:"code"`
+}
+
+// InitiateVerification starts the verification of the email address or mobile number of the user.
+func (u *CidaasUtils) InitiateVerification(ctx context.Context, sub string, method VerificationMethod) (*Verification, error) {
+	token, err := u.GetMyAccessToken()
+	if err != nil {
+		return nil, err
+	}
+
+	medium, processingType := method.medium()
+	body := verificationInitiateRequest{Sub: sub, VerificationMedium: medium, ProcessingType: processingType, ClientID: u.options.ClientID}
+
+	var result verificationInitiateResponse
+	err = u.doRequest(&RequestInit{Operation: "InitiateVerification", Path: verificationInitiateEndpoint, Token: token.Raw, Method: "POST", BodyJSON: body, Context: ctx}, &result)
+	if err != nil {
+		return nil, err
+	}
+
+	result.Data.Sub = sub
+	result.Data.Method = method
+	return &result.Data, nil
+}
+
+// VerifyCode completes the verification with the code the user received.
+// A wrong or expired code results in a CodeError matching InvalidCodeError, other errors like rate limits are returned as they are.
+func (u *CidaasUtils) VerifyCode(ctx context.Context, verification *Verification, code string) error {
+	body := verificationVerifyRequest{ID: verification.ID, Code: code}
+
+	var result SimpleStatusResponse
+	err := u.doRequest(&RequestInit{Operation: "VerifyCode", Path: verificationVerifyEndpoint, Method: "POST", BodyJSON: body, Context: ctx}, &result)
+	if verification.Sub != "" {
+		u.invalidateUserProfiles(ctx, verification.Sub)
+	}
+	if err != nil {
+		return toCodeError(err)
+	}
+
+	if !result.Success {
+		return InvalidCodeError
+	}
+	return nil
+}
+
+// GetVerificationStatus returns which contact information of the user is verified, based on the user profile.
+func (u *CidaasUtils) GetVerificationStatus(ctx context.Context, sub string) (*VerificationStatus, error) {
+	info, err := u.getUserProfile(ctx, sub)
+	if err != nil {
+		return nil, err
+	}
+
+	return &VerificationStatus{
+		Email:                info.Identity.Email,
+		EmailVerified:        info.Identity.EmailVerified,
+		MobileNumber:         info.Identity.MobileNumber,
+		MobileNumberVerified: info.Identity.MobileNumberVerified,
+	}, nil
+}
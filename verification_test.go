@@ -0,0 +1,86 @@
+package cidaasutils
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCidaasUtils_Verification(t *testing.T) {
+	utils, server := mockAdminUtils(func(writer http.ResponseWriter, request *http.Request) {
+		var body map[string]interface{}
+		json.NewDecoder(request.Body).Decode(&body)
+
+		switch request.URL.Path {
+		case "/" + verificationInitiateEndpoint:
+			assert.Equal(t, "sub-1", body["sub"])
+			assert.Equal(t, "sms", body["verificationMedium"])
+			assert.Equal(t, "CODE", body["processingType"])
+			writer.Write([]byte(`{"success":true,"status":200,"data":{"accvid":"verification-1"}}`))
+		case "/" + verificationVerifyEndpoint:
+			assert.Equal(t, "verification-1", body["accvid"])
+			if body["code"] == "throttled" {
+				writer.WriteHeader(http.StatusTooManyRequests)
+				return
+			}
+			if body["code"] != "123456" {
+				writer.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			writer.Write([]byte(`{"success":true,"status":200}`))
+		default:
+			writer.Write([]byte(`{"success":true,"data":{"identity":{"email":"test@example.com","email_verified":true,"mobile_number":"+4912345"}}}`))
+		}
+	})
+	defer server.Close()
+
+	ctx := context.Background()
+	verification, err := utils.InitiateVerification(ctx, "sub-1", VerificationSMSCode)
+	assert.Nil(t, err)
+	assert.Equal(t, &Verification{ID: "verification-1", Sub: "sub-1", Method: VerificationSMSCode}, verification)
+
+	err = utils.VerifyCode(ctx, verification, "000000")
+	assert.True(t, errors.Is(err, InvalidCodeError))
+	assert.Equal(t, 400, AsCidaasError(err).StatusCode)
+	err = utils.VerifyCode(ctx, verification, "throttled")
+	assert.True(t, IsRateLimited(err))
+	assert.False(t, errors.Is(err, InvalidCodeError))
+	assert.Nil(t, utils.VerifyCode(ctx, verification, "123456"))
+
+	status, err := utils.GetVerificationStatus(ctx, "sub-1")
+	assert.Nil(t, err)
+	assert.Equal(t, &VerificationStatus{Email: "test@example.com", EmailVerified: true, MobileNumber: "+4912345"}, status)
+}
+
+func TestCidaasUtils_JWTInterceptor_Verified(t *testing.T) {
+	utils := mockUtils()
+	token := signTestToken(jwt.MapClaims{"iss": "https://example.com", "sub": "test", "email_verified": true})
+
+	tests := []struct {
+		option JWTInterceptorOption
+		status int
+	}{
+		{WithVerifiedEmail(), 200},
+		{WithVerifiedPhoneNumber(), 403},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "", nil)
+		req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
+
+		utils.JWTInterceptor(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			assert.True(t, GetAuthContext(request.Context()).EmailVerified)
+			writer.WriteHeader(200)
+		}), test.option).ServeHTTP(w, req)
+
+		assert.Equal(t, test.status, w.Result().StatusCode)
+	}
+}
@@ -0,0 +1,339 @@
+package cidaasutils
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// InvalidWebhookSignatureError is returned if a webhook has no valid signature or API key.
+var InvalidWebhookSignatureError = errors.New("invalid cidaas webhook signature")
+
+// Headers used by Cidaas to authenticate webhooks.
+const (
+	WebhookSignatureHeader = "X-Cidaas-Signature"
+	WebhookAPIKeyHeader    = "X-API-Key"
+)
+
+// maxWebhookBodySize is the maximum accepted size of a webhook payload.
+const maxWebhookBodySize = 1 << 20
+
+// WebhookEventType is the type of a Cidaas webhook event.
+type WebhookEventType string
+
+const (
+	WebhookUserCreated WebhookEventType = "ACCOUNT_CREATED"
+	WebhookUserUpdated WebhookEventType = "ACCOUNT_MODIFIED"
+	WebhookUserDeleted WebhookEventType = "ACCOUNT_DELETED"
+	WebhookLogin       WebhookEventType = "LOGIN_WITH_CIDAAS"
+)
+
+// WebhookEvent contains the fields all webhook events share.
+type WebhookEvent struct {
+	ID          string           `json:"id"`
+	Type        WebhookEventType `json:"eventType"`
+	CreatedTime Timestamp        `json:"createdTime"`
+	Sub         string           `json:"sub"`
+	// Payload is the complete event as sent by Cidaas.
+	Payload json.RawMessage `json:"-"`
+}
+
+// UserCreatedEvent is sent after a user registered or was created by an admin.
+type UserCreatedEvent struct {
+	WebhookEvent
+	User UserInfo `json:"userInfo"`
+}
+
+// UserUpdatedEvent is sent after the profile of a user changed.
+type UserUpdatedEvent struct {
+	WebhookEvent
+	User UserInfo `json:"userInfo"`
+}
+
+// UserDeletedEvent is sent after a user was deleted.
+type UserDeletedEvent struct {
+	WebhookEvent
+}
+
+// LoginEvent is sent after a user logged in.
+type LoginEvent struct {
+	WebhookEvent
+	ClientID  string `json:"clientId"`
+	Provider  string `json:"provider"`
+	IPAddress string `json:"ipAddress"`
+	UserAgent string `json:"userAgent"`
+}
+
+// WebhookDeduplicator remembers the IDs of handled events, Cidaas may deliver an event more than once.
+// Implement it to share the IDs between several instances.
+type WebhookDeduplicator interface {
+	// MarkSeen remembers the ID and returns whether it was seen before.
+	MarkSeen(ctx context.Context, id string) (bool, error)
+	// Forget removes the ID again, e.g. because the event could not be handled.
+	Forget(ctx context.Context, id string) error
+}
+
+// MemoryWebhookDeduplicator is an in-memory WebhookDeduplicator
+// which remembers the IDs for a fixed time window.
+type MemoryWebhookDeduplicator struct {
+	window time.Duration
+	now    func() time.Time
+
+	mu   sync.Mutex
+	seen map[string]time.Time
+}
+
+// NewMemoryWebhookDeduplicator creates a MemoryWebhookDeduplicator which remembers IDs for window.
+func NewMemoryWebhookDeduplicator(window time.Duration) *MemoryWebhookDeduplicator {
+	return &MemoryWebhookDeduplicator{window: window, now: time.Now, seen: map[string]time.Time{}}
+}
+
+// MarkSeen implements WebhookDeduplicator.
+func (d *MemoryWebhookDeduplicator) MarkSeen(_ context.Context, id string) (bool, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := d.now()
+	for key, seenAt := range d.seen {
+		if now.Sub(seenAt) >= d.window {
+			delete(d.seen, key)
+		}
+	}
+
+	if _, ok := d.seen[id]; ok {
+		return true, nil
+	}
+	d.seen[id] = now
+	return false, nil
+}
+
+// Forget implements WebhookDeduplicator.
+func (d *MemoryWebhookDeduplicator) Forget(_ context.Context, id string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.seen, id)
+	return nil
+}
+
+// WebhookOptions configure the WebhookHandler. At least one of Secret and APIKey has to be set.
+type WebhookOptions struct {
+	// Secret verifies the HMAC-SHA256 signature of the payload sent in the X-Cidaas-Signature header.
+	Secret string
+
+	// APIKey has to be sent in the X-API-Key header.
+	APIKey string
+
+	// Deduplicator drops events which were already handled.
+	// Default is a MemoryWebhookDeduplicator with a window of 24 hours.
+	Deduplicator WebhookDeduplicator
+}
+
+// WebhookHandler is an http.Handler which receives Cidaas webhooks.
+// It verifies the request, drops duplicate deliveries and calls the registered handlers.
+// If a handler fails the request is answered with 500, so that Cidaas delivers the event again.
+//
+//	webhooks := utils.WebhookHandler(&WebhookOptions{Secret: secret})
+//	webhooks.OnUserCreated(func(ctx context.Context, event *UserCreatedEvent) error {
+//		...
+//	})
+//	http.Handle("/webhooks/cidaas", webhooks)
+type WebhookHandler struct {
+	utils   *CidaasUtils
+	options *WebhookOptions
+
+	mu          sync.RWMutex
+	userCreated []func(ctx context.Context, event *UserCreatedEvent) error
+	userUpdated []func(ctx context.Context, event *UserUpdatedEvent) error
+	userDeleted []func(ctx context.Context, event *UserDeletedEvent) error
+	login       []func(ctx context.Context, event *LoginEvent) error
+	any         []func(ctx context.Context, event *WebhookEvent) error
+}
+
+// WebhookHandler creates a handler for Cidaas webhooks.
+func (u *CidaasUtils) WebhookHandler(options *WebhookOptions) *WebhookHandler {
+	if options == nil {
+		options = &WebhookOptions{}
+	}
+	if options.Deduplicator == nil {
+		options.Deduplicator = NewMemoryWebhookDeduplicator(24 * time.Hour)
+	}
+	return &WebhookHandler{utils: u, options: options}
+}
+
+// OnUserCreated registers a handler for WebhookUserCreated events.
+func (h *WebhookHandler) OnUserCreated(handler func(ctx context.Context, event *UserCreatedEvent) error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.userCreated = append(h.userCreated, handler)
+}
+
+// OnUserUpdated registers a handler for WebhookUserUpdated events.
+func (h *WebhookHandler) OnUserUpdated(handler func(ctx context.Context, event *UserUpdatedEvent) error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.userUpdated = append(h.userUpdated, handler)
+}
+
+// OnUserDeleted registers a handler for WebhookUserDeleted events.
+func (h *WebhookHandler) OnUserDeleted(handler func(ctx context.Context, event *UserDeletedEvent) error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.userDeleted = append(h.userDeleted, handler)
+}
+
+// OnLogin registers a handler for WebhookLogin events.
+func (h *WebhookHandler) OnLogin(handler func(ctx context.Context, event *LoginEvent) error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.login = append(h.login, handler)
+}
+
+// OnEvent registers a handler which is called for all events, including unknown event types.
+func (h *WebhookHandler) OnEvent(handler func(ctx context.Context, event *WebhookEvent) error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.any = append(h.any, handler)
+}
+
+func (h *WebhookHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	logger := h.utils.logger()
+	if request.Method != http.MethodPost {
+		writer.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(writer, request.Body, maxWebhookBodySize))
+	if err != nil {
+		writer.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if err := h.verify(request, body); err != nil {
+		logger.Warn("rejected cidaas webhook", "error", err)
+		writer.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	var event WebhookEvent
+	if err := json.Unmarshal(body, &event); err != nil || event.ID == "" {
+		logger.Warn("could not decode cidaas webhook", "error", err)
+		writer.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	event.Payload = body
+
+	ctx := request.Context()
+	seen, err := h.options.Deduplicator.MarkSeen(ctx, event.ID)
+	if err != nil {
+		logger.Error("could not deduplicate cidaas webhook", "id", event.ID, "error", err)
+		writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if seen {
+		logger.Debug("dropped duplicate cidaas webhook", "id", event.ID, "type", event.Type)
+		writer.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if err := h.dispatch(ctx, &event); err != nil {
+		logger.Error("could not handle cidaas webhook", "id", event.ID, "type", event.Type, "error", err)
+		if err := h.options.Deduplicator.Forget(ctx, event.ID); err != nil {
+			logger.Error("could not forget cidaas webhook", "id", event.ID, "error", err)
+		}
+		writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	writer.WriteHeader(http.StatusOK)
+}
+
+// verify checks the signature and the API key of the request.
+func (h *WebhookHandler) verify(request *http.Request, body []byte) error {
+	if h.options.Secret == "" && h.options.APIKey == "" {
+		return fmt.Errorf("%w: neither secret nor api key configured", InvalidWebhookSignatureError)
+	}
+
+	if h.options.APIKey != "" {
+		apiKey := request.Header.Get(WebhookAPIKeyHeader)
+		if subtle.ConstantTimeCompare([]byte(apiKey), []byte(h.options.APIKey)) != 1 {
+			return fmt.Errorf("%w: wrong api key", InvalidWebhookSignatureError)
+		}
+	}
+
+	if h.options.Secret != "" {
+		signature, err := hex.DecodeString(strings.TrimPrefix(request.Header.Get(WebhookSignatureHeader), "sha256="))
+		if err != nil || !hmac.Equal(signature, SignWebhook(h.options.Secret, body)) {
+			return InvalidWebhookSignatureError
+		}
+	}
+	return nil
+}
+
+// SignWebhook returns the HMAC-SHA256 of the payload, e.g. to test webhook handlers.
+func SignWebhook(secret string, payload []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+// dispatch decodes the typed event and calls all handlers registered for it.
+func (h *WebhookHandler) dispatch(ctx context.Context, event *WebhookEvent) error {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for _, handler := range h.any {
+		if err := handler(ctx, event); err != nil {
+			return err
+		}
+	}
+
+	switch event.Type {
+	case WebhookUserCreated:
+		typed := UserCreatedEvent{WebhookEvent: *event}
+		if err := json.Unmarshal(event.Payload, &typed); err != nil {
+			return err
+		}
+		for _, handler := range h.userCreated {
+			if err := handler(ctx, &typed); err != nil {
+				return err
+			}
+		}
+	case WebhookUserUpdated:
+		typed := UserUpdatedEvent{WebhookEvent: *event}
+		if err := json.Unmarshal(event.Payload, &typed); err != nil {
+			return err
+		}
+		for _, handler := range h.userUpdated {
+			if err := handler(ctx, &typed); err != nil {
+				return err
+			}
+		}
+	case WebhookUserDeleted:
+		typed := UserDeletedEvent{WebhookEvent: *event}
+		for _, handler := range h.userDeleted {
+			if err := handler(ctx, &typed); err != nil {
+				return err
+			}
+		}
+	case WebhookLogin:
+		typed := LoginEvent{WebhookEvent: *event}
+		if err := json.Unmarshal(event.Payload, &typed); err != nil {
+			return err
+		}
+		for _, handler := range h.login {
+			if err := handler(ctx, &typed); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package cidaasutils
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func sendWebhook(handler http.Handler, payload string, header string, value string) int {
+	request := httptest.NewRequest("POST", "/webhooks", strings.NewReader(payload))
+	if header != "" {
+		request.Header.Set(header, value)
+	}
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	return recorder.Code
+}
+
+func signedWebhook(handler http.Handler, payload string) int {
+	signature := "sha256=" + hex.EncodeToString(SignWebhook("secret", []byte(payload)))
+	return sendWebhook(handler, payload, WebhookSignatureHeader, signature)
+}
+
+func TestWebhookHandler_Verify(t *testing.T) {
+	utils := mockUtils()
+	payload := `{"id":"1","eventType":"ACCOUNT_DELETED","sub":"test"}`
+
+	handler := utils.WebhookHandler(&WebhookOptions{Secret: "secret"})
+	assert.Equal(t, http.StatusUnauthorized, sendWebhook(handler, payload, "", ""))
+	assert.Equal(t, http.StatusUnauthorized, sendWebhook(handler, payload, WebhookSignatureHeader, "abc"))
+	assert.Equal(t, http.StatusUnauthorized, sendWebhook(handler, payload, WebhookSignatureHeader, hex.EncodeToString(SignWebhook("wrong", []byte(payload)))))
+	assert.Equal(t, http.StatusOK, signedWebhook(handler, payload))
+
+	handler = utils.WebhookHandler(&WebhookOptions{APIKey: "key"})
+	assert.Equal(t, http.StatusUnauthorized, sendWebhook(handler, payload, WebhookAPIKeyHeader, "wrong"))
+	assert.Equal(t, http.StatusOK, sendWebhook(handler, payload, WebhookAPIKeyHeader, "key"))
+
+	handler = utils.WebhookHandler(nil)
+	assert.Equal(t, http.StatusUnauthorized, sendWebhook(handler, payload, WebhookAPIKeyHeader, ""))
+
+	request := httptest.NewRequest("GET", "/webhooks", nil)
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	assert.Equal(t, http.StatusMethodNotAllowed, recorder.Code)
+}
+
+func TestWebhookHandler_Events(t *testing.T) {
+	handler := mockUtils().WebhookHandler(&WebhookOptions{Secret: "secret"})
+
+	var created *UserCreatedEvent
+	var login *LoginEvent
+	var types []WebhookEventType
+	handler.OnUserCreated(func(ctx context.Context, event *UserCreatedEvent) error {
+		created = event
+		return nil
+	})
+	handler.OnLogin(func(ctx context.Context, event *LoginEvent) error {
+		login = event
+		return nil
+	})
+	handler.OnEvent(func(ctx context.Context, event *WebhookEvent) error {
+		types = append(types, event.Type)
+		return nil
+	})
+
+	assert.Equal(t, http.StatusOK, signedWebhook(handler, `{"id":"1","eventType":"ACCOUNT_CREATED","createdTime":"2021-03-01T10:00:00Z","sub":"test","userInfo":{"identity":{"sub":"test","email":"test@example.com"},"roles":["USER"]}}`))
+	assert.Equal(t, "1", created.ID)
+	assert.Equal(t, "test", created.Sub)
+	assert.Equal(t, time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC), created.CreatedTime.UTC())
+	assert.Equal(t, "test@example.com", created.User.Identity.Email)
+	assert.Equal(t, []string{"USER"}, created.User.Roles)
+
+	assert.Equal(t, http.StatusOK, signedWebhook(handler, `{"id":"2","eventType":"LOGIN_WITH_CIDAAS","sub":"test","clientId":"app","provider":"self"}`))
+	assert.Equal(t, "app", login.ClientID)
+
+	assert.Equal(t, http.StatusOK, signedWebhook(handler, `{"id":"3","eventType":"SOMETHING_NEW","sub":"test"}`))
+	assert.Equal(t, []WebhookEventType{WebhookUserCreated, WebhookLogin, "SOMETHING_NEW"}, types)
+
+	assert.Equal(t, http.StatusBadRequest, signedWebhook(handler, `{"eventType":"ACCOUNT_CREATED"}`))
+	assert.Equal(t, http.StatusBadRequest, signedWebhook(handler, `not json`))
+}
+
+func TestWebhookHandler_Deduplicate(t *testing.T) {
+	handler := mockUtils().WebhookHandler(&WebhookOptions{Secret: "secret"})
+
+	calls := 0
+	fail := true
+	handler.OnUserDeleted(func(ctx context.Context, event *UserDeletedEvent) error {
+		calls++
+		if fail {
+			return errors.New("database down")
+		}
+		return nil
+	})
+
+	payload := `{"id":"1","eventType":"ACCOUNT_DELETED","sub":"test"}`
+	// failed events are delivered again by Cidaas and must not be dropped
+	assert.Equal(t, http.StatusInternalServerError, signedWebhook(handler, payload))
+	fail = false
+	assert.Equal(t, http.StatusOK, signedWebhook(handler, payload))
+	assert.Equal(t, http.StatusOK, signedWebhook(handler, payload))
+	assert.Equal(t, 2, calls)
+}
+
+func TestMemoryWebhookDeduplicator(t *testing.T) {
+	now := time.Now()
+	deduplicator := NewMemoryWebhookDeduplicator(time.Hour)
+	deduplicator.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	seen, _ := deduplicator.MarkSeen(ctx, "1")
+	assert.False(t, seen)
+	seen, _ = deduplicator.MarkSeen(ctx, "1")
+	assert.True(t, seen)
+
+	now = now.Add(time.Hour)
+	seen, _ = deduplicator.MarkSeen(ctx, "1")
+	assert.False(t, seen)
+}